@@ -0,0 +1,270 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ActionFactory creates a compute action from the numeric parameters given
+// in the action spec, e.g. 0.1 for "trim(0.1)"
+type ActionFactory func(params []float64) (ComputeAction, error)
+
+// ActionInfo describes a compute action available by name in compute specs
+type ActionInfo struct {
+	Name        string
+	Description string
+	Params      []string      // parameter names, their number is the arity
+	New         ActionFactory // creates the action for the given parameters
+	Expand      []string      // actions a composite action expands to
+}
+
+// Usage returns how the action is written in a compute spec, e.g. "q(x)"
+func (a ActionInfo) Usage() string {
+	if len(a.Params) == 0 {
+		return a.Name
+	}
+	return a.Name + "(" + strings.Join(a.Params, ", ") + ")"
+}
+
+// actionRegistry contains all available compute actions in the order in
+// which they were registered
+var actionRegistry = struct {
+	sync.RWMutex
+	byName map[string]int
+	infos  []ActionInfo
+}{byName: make(map[string]int)}
+
+// actionNameRegexp matches valid compute action names
+var actionNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z_0-9]*$`)
+
+// RegisterAction makes a compute action available by name in the compute
+// specs of all subsequently created Pipelines. Actions without parameters
+// are written by name only, all others as "name(p1, p2, ...)". Names have to
+// be unique.
+func RegisterAction(info ActionInfo) error {
+	if !actionNameRegexp.MatchString(info.Name) {
+		return fmt.Errorf("invalid compute action name %q", info.Name)
+	}
+	if info.New == nil && len(info.Expand) == 0 {
+		return fmt.Errorf("compute action %s requires a factory", info.Name)
+	}
+
+	actionRegistry.Lock()
+	defer actionRegistry.Unlock()
+	if _, ok := actionRegistry.byName[info.Name]; ok {
+		return fmt.Errorf("compute action %s is already registered", info.Name)
+	}
+	actionRegistry.byName[info.Name] = len(actionRegistry.infos)
+	actionRegistry.infos = append(actionRegistry.infos, info)
+	return nil
+}
+
+// unregisterAction removes the named compute action from the registry
+func unregisterAction(name string) {
+	actionRegistry.Lock()
+	defer actionRegistry.Unlock()
+	i, ok := actionRegistry.byName[name]
+	if !ok {
+		return
+	}
+	actionRegistry.infos = append(actionRegistry.infos[:i], actionRegistry.infos[i+1:]...)
+	delete(actionRegistry.byName, name)
+	for j := i; j < len(actionRegistry.infos); j++ {
+		actionRegistry.byName[actionRegistry.infos[j].Name] = j
+	}
+}
+
+// RegisterComputeAction registers a compute action without parameters
+func RegisterComputeAction(name string, action ComputeAction) error {
+	if action == nil {
+		return fmt.Errorf("invalid compute action %q", name)
+	}
+	return RegisterAction(ActionInfo{Name: name, Description: "custom action " + name,
+		New: func([]float64) (ComputeAction, error) { return action, nil }})
+}
+
+// Actions returns all registered compute actions in registration order
+func Actions() []ActionInfo {
+	actionRegistry.RLock()
+	defer actionRegistry.RUnlock()
+	infos := make([]ActionInfo, len(actionRegistry.infos))
+	copy(infos, actionRegistry.infos)
+	return infos
+}
+
+// lookupAction returns the registered compute action of the given name
+func lookupAction(name string) (ActionInfo, bool) {
+	actionRegistry.RLock()
+	defer actionRegistry.RUnlock()
+	i, ok := actionRegistry.byName[name]
+	if !ok {
+		return ActionInfo{}, false
+	}
+	return actionRegistry.infos[i], true
+}
+
+// fixed returns an ActionFactory for actions without parameters
+func fixed(action ComputeAction) ActionFactory {
+	return func([]float64) (ComputeAction, error) { return action, nil }
+}
+
+// quantileFactory returns an ActionFactory for quantiles given on a scale
+// from 0 to scale, i.e. 100 for percentiles and 1 for quantiles
+func quantileFactory(scale float64) ActionFactory {
+	return func(params []float64) (ComputeAction, error) {
+		q := params[0]
+		if q < 0 || q > scale {
+			return nil, fmt.Errorf("expected a value between 0 and %g", scale)
+		}
+		q /= scale
+		return func(x []float64) float64 { return quantile(x, q) }, nil
+	}
+}
+
+// builtinActions lists the compute actions provided by pst
+var builtinActions = []ActionInfo{
+	{Name: "mean", Description: "compute row mean", New: fixed(mean)},
+	{Name: "std", Description: "compute row standard deviation",
+		New: fixed(func(x []float64) float64 { return math.Sqrt(variance(x)) })},
+	{Name: "var", Description: "compute row variance", New: fixed(variance)},
+	{Name: "median", Description: "compute row median", New: fixed(median)},
+	{Name: "max", Description: "compute maximum value of row", New: fixed(max)},
+	{Name: "min", Description: "compute minimum value of row", New: fixed(min)},
+	{Name: "sum", Description: "compute sum of row", New: fixed(sum)},
+	{Name: "count", Description: "compute number of values in row", New: fixed(count)},
+	{Name: "range", Description: "compute difference between maximum and minimum of row",
+		New: fixed(valueRange)},
+	{Name: "mode", Description: "compute most frequent value of row (smallest if tied)",
+		New: fixed(mode)},
+	{Name: "skew", Description: "compute skewness g1 of row", New: fixed(skewness)},
+	{Name: "kurt", Description: "compute excess kurtosis g2 of row", New: fixed(kurtosis)},
+	{Name: "sem", Description: "compute standard error of the mean of row", New: fixed(sem)},
+	{Name: "mad", Description: "compute median absolute deviation of row", New: fixed(mad)},
+	{Name: "gmean", Description: "compute geometric mean of row", New: fixed(geometricMean)},
+	{Name: "hmean", Description: "compute harmonic mean of row", New: fixed(harmonicMean)},
+	{Name: "trim", Description: "compute mean of row without the fraction x of its " +
+		"smallest and largest values with 0 <= x < 0.5, e.g. trim(0.1)",
+		Params: []string{"x"},
+		New: func(params []float64) (ComputeAction, error) {
+			frac := params[0]
+			if frac < 0 || frac >= 0.5 {
+				return nil, fmt.Errorf("expected a value between 0 and 0.5")
+			}
+			return func(x []float64) float64 { return trimmedMean(x, frac) }, nil
+		}},
+	{Name: "p", Description: "compute Nth percentile of row with 0 <= N <= 100, " +
+		"e.g. p(95) or p99.9", Params: []string{"N"}, New: quantileFactory(100)},
+	{Name: "q", Description: "compute x quantile of row with 0 <= x <= 1, e.g. q(0.25)",
+		Params: []string{"x"}, New: quantileFactory(1)},
+	{Name: "iqr", Description: "compute interquartile range of row", New: fixed(iqr)},
+	{Name: "fivenum", Description: "compute five number summary (min, q(0.25), " +
+		"median, q(0.75), max) as five columns",
+		Expand: []string{"min", "q(0.25)", "median", "q(0.75)", "max"}},
+}
+
+func init() {
+	for _, info := range builtinActions {
+		if err := RegisterAction(info); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// actionCallRegexp matches compute actions of the form "name" and
+// "name(params)" while actionSuffixRegexp matches the short form "nameN"
+// of actions with a single parameter, e.g. "p95"
+var (
+	actionCallRegexp   = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z_0-9]*)(?:\((.*)\))?$`)
+	actionSuffixRegexp = regexp.MustCompile(`^([a-zA-Z_]+)([0-9.]+)$`)
+)
+
+// parseAction splits a compute action into its name and parameters
+func parseAction(action string) (string, []float64, error) {
+
+	name, args := action, ""
+	if m := actionCallRegexp.FindStringSubmatch(action); m != nil {
+		name, args = m[1], m[2]
+	}
+	if _, ok := lookupAction(name); !ok && args == "" {
+		if s := actionSuffixRegexp.FindStringSubmatch(action); s != nil {
+			name, args = s[1], s[2]
+		}
+	}
+	if _, ok := lookupAction(name); !ok {
+		return "", nil, fmt.Errorf("Encountered unknown compute action %s", action)
+	}
+	if strings.TrimSpace(args) == "" {
+		return name, nil, nil
+	}
+
+	var params []float64
+	for _, a := range strings.Split(args, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid parameter %q in compute action %s", a, action)
+		}
+		params = append(params, v)
+	}
+	return name, params, nil
+}
+
+// newComputeAction creates the compute action described by action
+func newComputeAction(action string) (ComputeAction, error) {
+
+	name, params, err := parseAction(action)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := lookupAction(name)
+	if info.New == nil {
+		return nil, fmt.Errorf("compute action %s expands to several actions and "+
+			"cannot be nested", action)
+	}
+	if len(params) != len(info.Params) {
+		return nil, fmt.Errorf("compute action %s expects %d parameter(s) but got %d",
+			info.Usage(), len(info.Params), len(params))
+	}
+	act, err := info.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter in compute action %s: %s", action, err)
+	}
+	return act, nil
+}
+
+// parseComputeSpec parses the comma separated list of compute actions
+func parseComputeSpec(actions string) (ComputeSpec, error) {
+
+	items := expandActions(actions)
+	specs := make(ComputeSpec, len(items))
+	for i, val := range items {
+		act, err := newComputeAction(val)
+		if err != nil {
+			return specs, err
+		}
+		specs[i] = act
+	}
+	return specs, nil
+}
+
+// expandActions splits the comma separated list of compute actions into the
+// individual action names. Composite actions such as "fivenum" are expanded
+// into the actions they consist of.
+func expandActions(actions string) []string {
+	var items []string
+	for _, r := range splitArguments(actions) {
+		val := strings.TrimSpace(r)
+		if info, ok := lookupAction(val); ok && len(info.Expand) != 0 {
+			items = append(items, info.Expand...)
+		} else {
+			items = append(items, val)
+		}
+	}
+	return items
+}
@@ -0,0 +1,234 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// chunkSize is the approximate size of the blocks of lines into which large
+// inputs are split for parallel tokenizing
+var chunkSize = 1 << 20
+
+// minChunkedSize is the minimum size of regular files which are tokenized in
+// chunks. Smaller files as well as pipes and terminals are read line by line.
+var minChunkedSize int64 = 4 << 20
+
+// chunkable tests if r is a regular file of at least minChunkedSize bytes
+func chunkable(r io.Reader) bool {
+	f, ok := r.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular() && info.Size() >= minChunkedSize
+}
+
+// chunkResult holds the records of a tokenized chunk or the error which
+// occurred while reading it
+type chunkResult struct {
+	records [][]string
+	err     error
+}
+
+// chunkJob is a chunk of complete lines waiting to be tokenized. The records
+// are delivered via result.
+type chunkJob struct {
+	data   []byte
+	line   int // number of lines preceding the chunk
+	result chan<- chunkResult
+}
+
+// chunkedReader is a recordReader for line oriented files which splits its
+// input at newline boundaries into chunks. The chunks are tokenized by a pool
+// of workers while Read returns the records in their original order.
+// Close has to be called to stop the background processing.
+type chunkedReader struct {
+	sepFun  func(rune) bool
+	whole   bool
+	maxLine int // maximum line length in bytes, unlimited if 0
+
+	order chan chan chunkResult // pending chunks in input order
+	jobs  chan chunkJob
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup // tracks the splitting goroutine
+
+	records [][]string // records of the current chunk
+	pos     int
+	err     error
+}
+
+// getChunkedReaderFunc returns a closure creating chunkedReaders with the
+// requested number of workers. The remaining arguments are as for
+// getRecordReaderFunc in line mode.
+func getChunkedReaderFunc(inputSep string, whole bool, workers,
+	maxLine int) recordReaderFunc {
+
+	sepFun := getInputSepFunc(inputSep)
+	return func(r io.Reader) recordReader {
+		return newChunkedReader(r, sepFun, whole, workers, maxLine)
+	}
+}
+
+// newChunkedReader starts splitting r into chunks which are tokenized by
+// the given number of workers. Lines longer than maxLine bytes are rejected
+// unless maxLine is 0.
+func newChunkedReader(r io.Reader, sepFun func(rune) bool, whole bool,
+	workers, maxLine int) *chunkedReader {
+
+	c := &chunkedReader{
+		sepFun:  sepFun,
+		whole:   whole,
+		maxLine: maxLine,
+		order:   make(chan chan chunkResult, 2*workers),
+		jobs:    make(chan chunkJob),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go c.tokenize()
+	}
+	c.wg.Add(1)
+	go c.split(r)
+	return c
+}
+
+// split reads r in blocks of chunkSize, cuts each block after its last
+// newline, and hands the chunks to the workers. Lines longer than a block
+// are kept in one piece by growing the buffer until their end is found.
+func (c *chunkedReader) split(r io.Reader) {
+
+	defer c.wg.Done()
+	defer close(c.order)
+	defer close(c.jobs)
+
+	// fail passes an error on to Read after all preceding chunks
+	fail := func(err error) {
+		result := make(chan chunkResult, 1)
+		result <- chunkResult{err: err}
+		select {
+		case c.order <- result:
+		case <-c.done:
+		}
+	}
+
+	// buf holds the incomplete line following the previous chunk, of which
+	// the first scanned bytes are known not to contain a newline
+	var buf []byte
+	scanned := 0
+	line := 0
+	for {
+		if cap(buf)-len(buf) < chunkSize {
+			grown := make([]byte, len(buf), 2*len(buf)+chunkSize)
+			copy(grown, buf)
+			buf = grown
+		}
+		n, err := io.ReadFull(r, buf[len(buf):len(buf)+chunkSize])
+		buf = buf[:len(buf)+n]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			fail(err)
+			return
+		}
+
+		chunk := buf
+		var rest []byte
+		if !eof {
+			i := bytes.LastIndexByte(buf[scanned:], '\n')
+			if i < 0 {
+				// stop reading lines which can not fit, allowing for a "\r"
+				if c.maxLine > 0 && len(buf) > c.maxLine+1 {
+					fail(lineTooLong(line+1, c.maxLine))
+					return
+				}
+				scanned = len(buf)
+				continue
+			}
+			chunk, rest = buf[:scanned+i+1], buf[scanned+i+1:]
+		}
+
+		if len(chunk) > 0 {
+			result := make(chan chunkResult, 1)
+			select {
+			case c.order <- result:
+			case <-c.done:
+				return
+			}
+			select {
+			case c.jobs <- chunkJob{data: chunk, line: line, result: result}:
+			case <-c.done:
+				return
+			}
+			line += bytes.Count(chunk, []byte{'\n'})
+		}
+		if eof {
+			return
+		}
+		// the chunk is owned by a worker now so the rest starts a new buffer
+		buf = append(make([]byte, 0, len(rest)+chunkSize), rest...)
+		scanned = 0
+	}
+}
+
+// tokenize splits the lines of each received chunk into records. The fields
+// of a chunk share a single string and slice. Overlong lines end the chunk
+// with an error.
+func (c *chunkedReader) tokenize() {
+	for job := range c.jobs {
+		var records [][]string
+		var err error
+		data := string(job.data)
+		cells := make([]string, 0, len(data)/4)
+		for len(data) > 0 {
+			line := data
+			if i := strings.IndexByte(data, '\n'); i >= 0 {
+				line, data = data[:i], data[i+1:]
+			} else {
+				data = ""
+			}
+			// drop carriage returns like bufio.ScanLines
+			if n := len(line); n > 0 && line[n-1] == '\r' {
+				line = line[:n-1]
+			}
+			if c.maxLine > 0 && len(line) > c.maxLine {
+				err = lineTooLong(job.line+len(records)+1, c.maxLine)
+				break
+			}
+			first := len(cells)
+			cells = appendFields(cells, line, c.sepFun, c.whole)
+			records = append(records, cells[first:len(cells):len(cells)])
+		}
+		job.result <- chunkResult{records: records, err: err}
+	}
+}
+
+// Read returns the fields of the next line
+func (c *chunkedReader) Read() ([]string, error) {
+	for c.pos >= len(c.records) {
+		if c.err != nil {
+			return nil, c.err
+		}
+		result, ok := <-c.order
+		if !ok {
+			c.err = io.EOF
+			continue
+		}
+		// the records preceding an error are returned first
+		r := <-result
+		c.records, c.pos, c.err = r.records, 0, r.err
+	}
+	c.pos++
+	return c.records[c.pos-1], nil
+}
+
+// Close stops splitting the input and waits until it is no longer read
+func (c *chunkedReader) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.wg.Wait()
+	return nil
+}
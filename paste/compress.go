@@ -0,0 +1,116 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// magic bytes identifying compressed input streams
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress detects compressed input streams by their magic bytes and
+// returns a reader delivering the decompressed data. Uncompressed streams
+// are returned as is. gzip and bzip2 are decompressed natively while xz and
+// zstd require the xz and zstd command line tools, respectively.
+// NOTE: Closing the returned reader does not close r.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return newCommandReader(br, "xz", "-dc")
+	case bytes.HasPrefix(magic, zstdMagic):
+		return newCommandReader(br, "zstd", "-dc")
+	}
+	return io.NopCloser(br), nil
+}
+
+// commandReader reads the output of an external decompression command which
+// is fed the compressed input stream
+type commandReader struct {
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	cmd    *exec.Cmd
+	done   bool
+}
+
+// newCommandReader starts the command name with args and returns a reader
+// for its output. The input is copied to the command by a goroutine of its
+// own so that Close does not have to wait for r, e.g. a pipe which is not
+// written to anymore.
+func newCommandReader(r io.Reader, name string, args ...string) (*commandReader, error) {
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s input requires the %s command: %s",
+			name, name, err)
+	}
+	c := &commandReader{cmd: exec.Command(path, args...)}
+	c.cmd.Stderr = &c.stderr
+	if c.stdin, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if c.stdout, err = c.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the %s command: %s", name, err)
+	}
+	go func() {
+		io.Copy(c.stdin, r)
+		c.stdin.Close()
+	}()
+	return c, nil
+}
+
+// Read reads decompressed data. Once the command finishes its exit status is
+// checked so that failed decompressions are reported instead of truncating
+// the input.
+func (c *commandReader) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF && !c.done {
+		c.done = true
+		if werr := c.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("%s failed: %s %s", c.cmd.Path, werr,
+				strings.TrimSpace(c.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+// Close stops the decompression command. Closing its input and output makes
+// it exit even if it is still waiting for input.
+func (c *commandReader) Close() error {
+	if c.done {
+		return nil
+	}
+	c.done = true
+	c.stdin.Close()
+	c.stdout.Close()
+	c.cmd.Wait()
+	return nil
+}
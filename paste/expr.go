@@ -0,0 +1,669 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// value is the result of evaluating an expression. Column references
+// evaluate to the raw column content which is converted into a number once
+// it is used in a numeric context.
+type value struct {
+	str   string
+	num   float64
+	isNum bool
+}
+
+// numValue creates a numeric value
+func numValue(f float64) value {
+	return value{num: f, isNum: true}
+}
+
+// boolValue creates a numeric value of 1 for true and 0 for false
+func boolValue(b bool) value {
+	if b {
+		return numValue(1)
+	}
+	return numValue(0)
+}
+
+// number returns the numeric representation of a value
+func (v value) number() (float64, error) {
+	if v.isNum {
+		return v.num, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v.str), 64)
+	if err != nil {
+		return 0, fmt.Errorf("non-numeric value %q used in numeric expression", v.str)
+	}
+	return f, nil
+}
+
+// compareNumber returns the numeric representation of a value compared with
+// a number. Non-numeric values are handled according to the missingSpec and
+// are NaN unless they are replaced or considered an error.
+func (v value) compareNumber(m missingSpec) (float64, error) {
+	if v.isNum {
+		return v.num, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v.str), 64)
+	if err == nil {
+		return f, nil
+	}
+	switch m.policy {
+	case failMissing:
+		return 0, fmt.Errorf("non-numeric value %q compared with a number", v.str)
+	case replaceMissing:
+		return m.value, nil
+	}
+	return math.NaN(), nil
+}
+
+// truth returns the truth value of a value. Numbers are true if they are
+// non-zero, strings if they are not empty.
+func (v value) truth() bool {
+	if v.isNum {
+		return v.num != 0
+	}
+	if f, err := strconv.ParseFloat(strings.TrimSpace(v.str), 64); err == nil {
+		return f != 0
+	}
+	return v.str != ""
+}
+
+// String returns the output representation of a value. Numbers are printed
+// in their shortest representation, column content as is.
+func (v value) String() string {
+	if v.isNum {
+		return strconv.FormatFloat(v.num, 'g', -1, 64)
+	}
+	return v.str
+}
+
+// expr is a compiled expression evaluated on the assembled row
+type expr func(row []string) (value, error)
+
+// exprFunc describes a function available in expressions
+type exprFunc struct {
+	arity int // number of arguments, -1 for one or more
+	fun   func(args []float64) float64
+}
+
+// exprFuncs lists the functions available in expressions
+var exprFuncs = map[string]exprFunc{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"log2":  {1, func(a []float64) float64 { return math.Log2(a[0]) }},
+	"log10": {1, func(a []float64) float64 { return math.Log10(a[0]) }},
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"tan":   {1, func(a []float64) float64 { return math.Tan(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"round": {1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min":   {-1, min},
+	"max":   {-1, max},
+	"mean":  {-1, mean},
+	"sum":   {-1, sum},
+}
+
+// parseExprList parses a comma separated list of expressions. maxCol is the
+// largest valid column index or -1 if the number of columns is not known.
+// Non-numeric values compared with numbers are handled according to the
+// missingSpec. It returns the compiled expressions and their source text.
+func parseExprList(input string, maxCol int, m missingSpec) ([]expr, []string, error) {
+
+	p := &exprParser{input: input, maxCol: maxCol, missing: m}
+	if err := p.tokenize(); err != nil {
+		return nil, nil, err
+	}
+
+	var exprs []expr
+	var sources []string
+	for {
+		start := p.tokens[p.pos].offset
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, nil, err
+		}
+		exprs = append(exprs, e)
+		sources = append(sources, strings.TrimSpace(input[start:p.tokens[p.pos].offset]))
+
+		if p.accept(",") {
+			continue
+		} else if p.peek().kind != tokEOF {
+			return nil, nil, p.errorf("unexpected %q", p.peek().text)
+		}
+		break
+	}
+	return exprs, sources, nil
+}
+
+// parseExpr parses a single expression. maxCol and the missingSpec are as
+// for parseExprList.
+func parseExpr(input string, maxCol int, m missingSpec) (expr, error) {
+
+	exprs, _, err := parseExprList(input, maxCol, m)
+	if err != nil {
+		return nil, err
+	} else if len(exprs) != 1 {
+		return nil, fmt.Errorf("expected a single expression but got %d in %q", len(exprs),
+			input)
+	}
+	return exprs[0], nil
+}
+
+// token kinds of the expression language
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+// token is a lexical token of an expression
+type token struct {
+	kind   int
+	text   string
+	offset int
+}
+
+// exprParser is a recursive descent parser for expressions. The grammar in
+// order of increasing precedence is
+//
+//	expr    = or [ "?" expr ":" expr ]
+//	or      = and { "||" and }
+//	and     = cmp { "&&" cmp }
+//	cmp     = add [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) add ]
+//	add     = mul { ( "+" | "-" ) mul }
+//	mul     = unary { ( "*" | "/" | "%" ) unary }
+//	unary   = ( "-" | "!" ) unary | power
+//	power   = primary [ "^" unary ]
+//	primary = number | string | column | ident "(" expr { "," expr } ")" | "(" expr ")"
+type exprParser struct {
+	input   string
+	maxCol  int
+	missing missingSpec // handling of non-numeric values compared with numbers
+	tokens  []token
+	pos     int
+}
+
+// errorf returns a parse error at the current position
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("error parsing expression %q at position %d: %s", p.input,
+		p.peek().offset, fmt.Sprintf(format, args...))
+}
+
+// tokenize splits the input into tokens
+func (p *exprParser) tokenize() error {
+
+	in := p.input
+	for i := 0; i < len(in); {
+		c := rune(in[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(in) && unicode.IsDigit(rune(in[i+1]))):
+			j := i
+			for j < len(in) && (unicode.IsDigit(rune(in[j])) || in[j] == '.') {
+				j++
+			}
+			// exponent
+			if j < len(in) && (in[j] == 'e' || in[j] == 'E') {
+				k := j + 1
+				if k < len(in) && (in[k] == '+' || in[k] == '-') {
+					k++
+				}
+				if k < len(in) && unicode.IsDigit(rune(in[k])) {
+					for j = k; j < len(in) && unicode.IsDigit(rune(in[j])); j++ {
+					}
+				}
+			}
+			p.tokens = append(p.tokens, token{tokNumber, in[i:j], i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(in) && (unicode.IsLetter(rune(in[j])) || unicode.IsDigit(rune(in[j])) ||
+				in[j] == '_') {
+				j++
+			}
+			p.tokens = append(p.tokens, token{tokIdent, in[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(in) && rune(in[j]) != c {
+				j++
+			}
+			if j == len(in) {
+				return fmt.Errorf("error parsing expression %q: unterminated string at "+
+					"position %d", in, i)
+			}
+			p.tokens = append(p.tokens, token{tokString, in[i+1 : j], i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "&&", "||"} {
+				if strings.HasPrefix(in[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("+-*/%^<>!?:(),", c) {
+					return fmt.Errorf("error parsing expression %q: unexpected character "+
+						"%q at position %d", in, c, i)
+				}
+				op = string(c)
+			}
+			p.tokens = append(p.tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{tokEOF, "end of expression", len(in)})
+	return nil
+}
+
+// peek returns the current token
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// accept consumes the current token if it is the operator op
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the operator op or returns an error
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf("expected %q but found %q", op, p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseExpr() (expr, error) {
+
+	cond, err := p.parseOr()
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	a, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return conditional(cond, a, b), nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+
+	x, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var y expr
+		if y, err = p.parseAnd(); err == nil {
+			x = logical(x, y, true)
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+
+	x, err := p.parseCmp()
+	for err == nil && p.accept("&&") {
+		var y expr
+		if y, err = p.parseCmp(); err == nil {
+			x = logical(x, y, false)
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseCmp() (expr, error) {
+
+	x, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			y, err := p.parseAdd()
+			if err != nil {
+				return nil, err
+			}
+			return comparison(op, x, y, p.missing), nil
+		}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseAdd() (expr, error) {
+
+	x, err := p.parseMul()
+	for err == nil {
+		op := p.peek().text
+		if !p.accept("+") && !p.accept("-") {
+			break
+		}
+		var y expr
+		if y, err = p.parseMul(); err == nil {
+			x = arithmetic(op, x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseMul() (expr, error) {
+
+	x, err := p.parseUnary()
+	for err == nil {
+		op := p.peek().text
+		if !p.accept("*") && !p.accept("/") && !p.accept("%") {
+			break
+		}
+		var y expr
+		if y, err = p.parseUnary(); err == nil {
+			x = arithmetic(op, x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic("-", constant(numValue(0)), x), nil
+	} else if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(row []string) (value, error) {
+			v, err := x(row)
+			return boolValue(!v.truth()), err
+		}, nil
+	}
+	return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (expr, error) {
+
+	x, err := p.parsePrimary()
+	if err != nil || !p.accept("^") {
+		return x, err
+	}
+	y, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return arithmetic("^", x, y), nil
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.pos++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", t.text)
+		}
+		return constant(numValue(f)), nil
+	case tokString:
+		p.pos++
+		return constant(value{str: t.text}), nil
+	case tokIdent:
+		p.pos++
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		if col, ok := columnIndex(t.text); ok {
+			if p.maxCol >= 0 && col > p.maxCol {
+				return nil, fmt.Errorf("error parsing expression %q: column %s is out "+
+					"of bounds, the largest column is c%d", p.input, t.text, p.maxCol)
+			}
+			return column(col), nil
+		}
+		return nil, fmt.Errorf("error parsing expression %q: unknown identifier %s, "+
+			"columns are referred to as c0, c1, ...", p.input, t.text)
+	case tokOp:
+		if p.accept("(") {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// parseCall parses the arguments of a call to the function named by t
+func (p *exprParser) parseCall(t token) (expr, error) {
+
+	var args []expr
+	if !p.accept(")") {
+		for {
+			a, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if t.text == "if" {
+		if len(args) != 3 {
+			return nil, p.errorf("if requires 3 arguments but got %d", len(args))
+		}
+		return conditional(args[0], args[1], args[2]), nil
+	}
+
+	f, ok := exprFuncs[t.text]
+	if !ok {
+		return nil, fmt.Errorf("error parsing expression %q: unknown function %s", p.input,
+			t.text)
+	}
+	if (f.arity == -1 && len(args) == 0) || (f.arity >= 0 && len(args) != f.arity) {
+		return nil, p.errorf("wrong number of arguments for function %s", t.text)
+	}
+	return func(row []string) (value, error) {
+		vals := make([]float64, len(args))
+		for i, a := range args {
+			v, err := a(row)
+			if err != nil {
+				return v, err
+			}
+			if vals[i], err = v.number(); err != nil {
+				return v, err
+			}
+		}
+		return numValue(f.fun(vals)), nil
+	}, nil
+}
+
+// columnIndex checks if name is a column reference of the form cN and
+// returns N
+func columnIndex(name string) (int, bool) {
+	if len(name) < 2 || name[0] != 'c' {
+		return 0, false
+	}
+	col, err := strconv.Atoi(name[1:])
+	return col, err == nil && col >= 0
+}
+
+// constant returns an expression evaluating to v
+func constant(v value) expr {
+	return func(row []string) (value, error) {
+		return v, nil
+	}
+}
+
+// column returns an expression evaluating to the content of column col
+func column(col int) expr {
+	return func(row []string) (value, error) {
+		if col >= len(row) {
+			return value{}, fmt.Errorf("column c%d does not exist, the row only has %d "+
+				"columns", col, len(row))
+		}
+		return value{str: row[col]}, nil
+	}
+}
+
+// conditional returns an expression evaluating to a if cond is true and to b
+// otherwise
+func conditional(cond, a, b expr) expr {
+	return func(row []string) (value, error) {
+		c, err := cond(row)
+		if err != nil {
+			return c, err
+		}
+		if c.truth() {
+			return a(row)
+		}
+		return b(row)
+	}
+}
+
+// logical returns an expression combining x and y via || if or is set and
+// via && otherwise. y is only evaluated if needed.
+func logical(x, y expr, or bool) expr {
+	return func(row []string) (value, error) {
+		v, err := x(row)
+		if err != nil {
+			return v, err
+		}
+		if v.truth() == or {
+			return boolValue(or), nil
+		}
+		w, err := y(row)
+		return boolValue(w.truth()), err
+	}
+}
+
+// comparison returns an expression comparing x and y. If either of them is a
+// number, e.g. a numeric literal, both are compared numerically and
+// non-numeric column values are handled according to the missingSpec.
+// Two column values are compared numerically if both are numbers and as
+// strings otherwise. Numeric comparisons involving NaN or missing values
+// are false.
+func comparison(op string, x, y expr, m missingSpec) expr {
+	return func(row []string) (value, error) {
+		v, err := x(row)
+		if err != nil {
+			return v, err
+		}
+		w, err := y(row)
+		if err != nil {
+			return w, err
+		}
+
+		var a, b float64
+		numeric := v.isNum || w.isNum
+		if numeric {
+			if a, err = v.compareNumber(m); err != nil {
+				return v, err
+			}
+			if b, err = w.compareNumber(m); err != nil {
+				return w, err
+			}
+		} else {
+			var errA, errB error
+			a, errA = v.number()
+			b, errB = w.number()
+			numeric = errA == nil && errB == nil
+		}
+
+		var c int
+		if !numeric {
+			c = strings.Compare(v.String(), w.String())
+		} else if math.IsNaN(a) || math.IsNaN(b) {
+			return boolValue(false), nil
+		} else if a < b {
+			c = -1
+		} else if a > b {
+			c = 1
+		}
+
+		switch op {
+		case "==":
+			return boolValue(c == 0), nil
+		case "!=":
+			return boolValue(c != 0), nil
+		case "<":
+			return boolValue(c < 0), nil
+		case "<=":
+			return boolValue(c <= 0), nil
+		case ">":
+			return boolValue(c > 0), nil
+		}
+		return boolValue(c >= 0), nil
+	}
+}
+
+// arithmetic returns an expression combining the numbers x and y via op
+func arithmetic(op string, x, y expr) expr {
+	return func(row []string) (value, error) {
+		v, err := x(row)
+		if err != nil {
+			return v, err
+		}
+		w, err := y(row)
+		if err != nil {
+			return w, err
+		}
+		a, err := v.number()
+		if err != nil {
+			return v, err
+		}
+		b, err := w.number()
+		if err != nil {
+			return w, err
+		}
+
+		switch op {
+		case "+":
+			return numValue(a + b), nil
+		case "-":
+			return numValue(a - b), nil
+		case "*":
+			return numValue(a * b), nil
+		case "/":
+			return numValue(a / b), nil
+		case "%":
+			return numValue(math.Mod(a, b)), nil
+		}
+		return numValue(math.Pow(a, b)), nil
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultNumberFormat is the printf format used for computed values unless
+// requested otherwise
+const defaultNumberFormat = "%15.15f"
+
+// shortestFormat selects the shortest representation which round trips to
+// the same float64 value
+const shortestFormat = "shortest"
+
+// numberFormat converts a computed value into its output representation
+type numberFormat func(float64) string
+
+// printfVerb matches a single printf floating point verb with optional flags,
+// width and precision
+var printfVerb = regexp.MustCompile(`^%([-+# 0]*)([0-9]*)(?:\.([0-9]+))?([eEfFgG])$`)
+
+// parseNumberFormat converts a printf floating point format such as "%g",
+// "%.6e", or "%.3f", or the keyword "shortest" into a numberFormat
+func parseNumberFormat(format string) (numberFormat, error) {
+	f := strings.TrimSpace(format)
+	if f == shortestFormat {
+		return func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }, nil
+	}
+	m := printfVerb.FindStringSubmatch(f)
+	if m == nil {
+		return nil, fmt.Errorf("invalid number format %q, expected a printf floating "+
+			"point verb such as %%g, %%.6e, or %%.3f, or %s", format, shortestFormat)
+	}
+	if m[1] != "" {
+		return func(v float64) string { return fmt.Sprintf(f, v) }, nil
+	}
+
+	// without flags the format is equivalent to strconv.FormatFloat padded to
+	// the requested width, which avoids the overhead of fmt for every value
+	width, _ := strconv.Atoi(m[2])
+	verb := m[4][0]
+	prec := -1
+	if m[3] != "" {
+		prec, _ = strconv.Atoi(m[3])
+	} else if verb != 'g' && verb != 'G' {
+		prec = 6
+	}
+	if verb == 'F' {
+		verb = 'f'
+	}
+	return func(v float64) string {
+		s := strconv.FormatFloat(v, verb, prec, 64)
+		if len(s) < width {
+			s = strings.Repeat(" ", width-len(s)) + s
+		}
+		return s
+	}, nil
+}
+
+// getNumberFormats parses the comma separated list of number formats and
+// returns the format for each of the named compute actions. Entries of the
+// form "action=format" apply to the given action only, a plain format
+// applies to all other actions.
+func getNumberFormats(formats string, actions []string) ([]numberFormat, error) {
+
+	defFormat, err := parseNumberFormat(defaultNumberFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	perAction := make(map[string]numberFormat)
+	if formats != "" {
+		for _, item := range strings.Split(formats, ",") {
+			name, format := "", item
+			if i := strings.Index(item, "="); i != -1 {
+				name, format = strings.TrimSpace(item[:i]), item[i+1:]
+			}
+
+			f, err := parseNumberFormat(format)
+			if err != nil {
+				return nil, err
+			}
+			if name == "" {
+				defFormat = f
+			} else {
+				perAction[name] = f
+			}
+		}
+	}
+
+	numFormats := make([]numberFormat, len(actions))
+	for i, a := range actions {
+		numFormats[i] = defFormat
+		if f, ok := perAction[a]; ok {
+			numFormats[i] = f
+		}
+	}
+
+	for name := range perAction {
+		found := false
+		for _, a := range actions {
+			found = found || a == name
+		}
+		if !found {
+			return nil, fmt.Errorf("number format provided for compute action %s "+
+				"which was not requested", name)
+		}
+	}
+	return numFormats, nil
+}
+
+// actionNames returns the names of the compute actions in the comma
+// separated list of compute actions
+func actionNames(actions string) []string {
+	if actions == "" {
+		return nil
+	}
+	return expandActions(actions)
+}
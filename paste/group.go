@@ -0,0 +1,189 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"strings"
+)
+
+// groupSpec describes the aggregation of the output rows grouped by the
+// values of their key columns. The compute actions are applied to the
+// values of the target columns of all rows within a group and one line is
+// printed per group.
+type groupSpec struct {
+	keys    ParseSpec      // key columns of the output row
+	targets ParseSpec      // aggregated columns, all non-key columns if empty
+	actions ComputeSpec    // compute actions applied to each group
+	formats []numberFormat // number format for each compute action
+	missing missingSpec    // handling of missing values
+
+	// groups are streamed as long as the rows are sorted by key and kept in
+	// memory by normalized key afterwards
+	current *group            // the current group in streaming mode
+	groups  map[string]*group // the groups in hash mode, nil in streaming mode
+	order   []*group          // groups in the order of their first row
+}
+
+// group holds the key and the target values of the rows of a single group
+type group struct {
+	key    []string
+	values []float64
+}
+
+// getGroupSpec parses the key and target columns of a group-by aggregation.
+// Both refer to the numCols columns of the output row and can be given by
+// name if names are provided.
+func getGroupSpec(keys, targets string, numCols int, names []string) (*groupSpec, error) {
+
+	var g groupSpec
+	var err error
+	if g.keys, err = parseColumnList(keys, names, numCols); err != nil {
+		return nil, fmt.Errorf("group key specification: %s", err)
+	}
+	if targets != "" {
+		if g.targets, err = parseColumnList(targets, names, numCols); err != nil {
+			return nil, fmt.Errorf("group target specification: %s", err)
+		}
+	}
+
+	if numCols >= 0 {
+		for _, c := range append(append(ParseSpec{}, g.keys...), g.targets...) {
+			if c >= numCols {
+				return nil, fmt.Errorf("group column %d is out of bounds, the output row "+
+					"has %d columns", c, numCols)
+			}
+		}
+	}
+	return &g, nil
+}
+
+// isKey tests if column c is one of the key columns
+func (g *groupSpec) isKey(c int) bool {
+	for _, k := range g.keys {
+		if k == c {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the provided row to its group. As long as the keys are sorted the
+// previous group is printed once a row with a larger key is encountered. The
+// first key smaller than its predecessor switches to hash mode, in which the
+// current and all following groups are kept in memory until flush. Rows of
+// groups printed before are then aggregated separately. The input lines of
+// the row are used for error reporting.
+func (g *groupSpec) add(output rowWriter, outRow []string, lines []int) error {
+
+	key := make([]string, len(g.keys))
+	for i, k := range g.keys {
+		if k >= len(outRow) {
+			return fmt.Errorf("group key column %d does not exist in %s", k,
+				g.missing.rowPosition(lines))
+		}
+		key[i] = outRow[k]
+	}
+
+	targets := g.targets
+	if len(targets) == 0 {
+		for c := range outRow {
+			if !g.isKey(c) {
+				targets = append(targets, c)
+			}
+		}
+	}
+	values := make([]float64, len(targets))
+	for i, c := range targets {
+		if c >= len(outRow) {
+			return fmt.Errorf("group target column %d does not exist in %s", c,
+				g.missing.rowPosition(lines))
+		}
+		v, err := parseValue(outRow[c], g.missing, lines, c)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+
+	if g.groups == nil {
+		cmp := -1
+		if g.current != nil {
+			cmp = compareGroupKeys(g.current.key, key)
+		}
+		if cmp == 0 {
+			g.current.values = append(g.current.values, values...)
+			return nil
+		} else if cmp < 0 {
+			if g.current != nil {
+				if err := g.current.print(output, g); err != nil {
+					return err
+				}
+			}
+			g.current = &group{key: key, values: values}
+			return nil
+		}
+		g.groups = map[string]*group{groupName(g.current.key): g.current}
+		g.order = []*group{g.current}
+		g.current = nil
+	}
+
+	name := groupName(key)
+	if c, ok := g.groups[name]; ok {
+		c.values = append(c.values, values...)
+		return nil
+	}
+	c := &group{key: key, values: values}
+	g.groups[name] = c
+	g.order = append(g.order, c)
+	return nil
+}
+
+// flush prints all groups which have not been printed yet
+func (g *groupSpec) flush(output rowWriter) error {
+	if g.current != nil {
+		return g.current.print(output, g)
+	}
+	for _, c := range g.order {
+		if err := c.print(output, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print prints the key of the group followed by the results of the compute
+// actions
+func (c *group) print(output rowWriter, g *groupSpec) error {
+
+	values := c.values
+	if g.missing.policy == skipMissing {
+		values = dropNaN(values)
+	}
+	outRow := append([]string{}, c.key...)
+	for i, a := range g.actions {
+		outRow = append(outRow, g.formats[i](a(values)))
+	}
+	return output.writeRow(outRow)
+}
+
+// groupName turns a group key into a string identifying the group. Numeric
+// key values are normalized, see normalizeKey.
+func groupName(key []string) string {
+	normalized := make([]string, len(key))
+	for i, k := range key {
+		normalized[i] = normalizeKey(k)
+	}
+	return strings.Join(normalized, "\x00")
+}
+
+// compareGroupKeys compares two group keys column by column, see compareKeys
+func compareGroupKeys(a, b []string) int {
+	for i := range a {
+		if cmp := compareKeys(a[i], b[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
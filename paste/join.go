@@ -0,0 +1,395 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// joinType describes which key values are kept during a key based join
+type joinType int
+
+const (
+	innerJoin joinType = iota // keys present in all files
+	leftJoin                  // keys present in the first file
+	outerJoin                 // keys present in any file
+)
+
+// joinSpec describes a key based join of the input files
+type joinSpec struct {
+	keys   []int    // key column for each file
+	keyPos []int    // position of the key among the extracted columns or -1
+	widths []int    // number of extracted columns for each file
+	names  []string // file names for error reporting
+	fill   string   // fill value for columns of files without matching key
+	kind   joinType
+}
+
+// getJoinSpec parses and checks the key and join type specs and assembles
+// the joinSpec for the input files
+func getJoinSpec(keys, kind string, inputs []*inputFile, inCols []ParseSpec,
+	headers [][]string) (*joinSpec, error) {
+
+	var j joinSpec
+	var err error
+	if j.keys, err = getKeySpec(keys, len(inputs), headers, inputWidths(inputs)); err != nil {
+		return nil, err
+	}
+	if j.kind, err = parseJoinType(kind); err != nil {
+		return nil, err
+	}
+	for i, in := range inputs {
+		pos := -1
+		for c, col := range inCols[i] {
+			if col == j.keys[i] {
+				pos = c
+				break
+			}
+		}
+		j.keyPos = append(j.keyPos, pos)
+		j.widths = append(j.widths, len(inCols[i]))
+		j.names = append(j.names, in.name)
+	}
+	return &j, nil
+}
+
+// parseJoinType converts the name of a join type into a joinType
+func parseJoinType(name string) (joinType, error) {
+	switch strings.TrimSpace(name) {
+	case "", "inner":
+		return innerJoin, nil
+	case "left":
+		return leftJoin, nil
+	case "outer":
+		return outerJoin, nil
+	}
+	return innerJoin, fmt.Errorf("unknown join type %s, expected one of inner, left, or outer",
+		name)
+}
+
+// getKeySpec parses the per file key column spec. The spec has the same
+// format as the input spec but each file entry has to consist of a single
+// column.
+func getKeySpec(keys string, numFiles int, headers [][]string, widths []int) ([]int, error) {
+
+	keySpecs, err := getInputSpec(keys, numFiles, headers, widths)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCols := make([]int, numFiles)
+	for i, k := range keySpecs {
+		if len(k) != 1 {
+			return nil, fmt.Errorf("key specification for file entry #%d has to consist "+
+				"of a single column", i)
+		}
+		keyCols[i] = k[0]
+	}
+	return keyCols, nil
+}
+
+// joinGroup holds the rows of an input file sharing a key and their lines in
+// the input file
+type joinGroup struct {
+	key   string // the key as written in the first row
+	rows  [][]string
+	lines []int
+}
+
+// joinRows returns a rowSource assembling rows by joining the rows of all
+// input files on their key column. The file parsers deliver the key as the
+// final entry of each row. The input files are merged as streams as long as
+// they are sorted by key, see mergeGroups.
+func joinRows(streams []*rowStream, errCh <-chan error, j joinSpec) rowSource {
+
+	nextGroups := mergeGroups(streams, errCh, j)
+	var pending [][]string
+	var pendingLines [][]int
+	return func() ([]string, []int, error) {
+		for len(pending) == 0 {
+			groups, err := nextGroups()
+			if err != nil || groups == nil {
+				return nil, nil, err
+			}
+			pending, pendingLines = combineGroups(groups, j)
+		}
+		row, lines := pending[0], pendingLines[0]
+		pending, pendingLines = pending[1:], pendingLines[1:]
+		return row, lines, nil
+	}
+}
+
+// keyedStream keeps track of the current row of an input file
+type keyedStream struct {
+	in       *rowStream
+	head     []string // current row without key, nil once the file is exhausted
+	key      string
+	line     int  // line of the current row in the input file
+	unsorted bool // the key of the current row is smaller than the previous one
+}
+
+// advance moves the stream to its next row and checks that the keys are
+// sorted
+func (k *keyedStream) advance(errCh <-chan error) error {
+
+	cols, line, err := k.in.receive(errCh)
+	if err != nil {
+		return err
+	}
+	if cols == nil {
+		k.head = nil
+		return nil
+	}
+
+	key := cols[len(cols)-1]
+	if k.line > 0 && compareKeys(key, k.key) < 0 {
+		k.unsorted = true
+	}
+	k.head = cols[:len(cols)-1]
+	k.key = key
+	k.line = line
+	return nil
+}
+
+// mergeGroups returns a function which merges the input files as streams.
+// Each call returns for the next smallest key the rows of each file with that
+// key or nil once all keys have been processed. Once a file turns out not to
+// be sorted by key, the rows of the current key and all remaining rows are
+// joined via hashGroups instead. Rows of keys returned before are not matched
+// with these rows.
+func mergeGroups(inputs []*rowStream, errCh <-chan error,
+	j joinSpec) func() ([]joinGroup, error) {
+
+	streams := make([]*keyedStream, len(inputs))
+	for i, in := range inputs {
+		streams[i] = &keyedStream{in: in}
+	}
+
+	// the groups are only used until the next call and can be reused
+	groups := make([]joinGroup, len(streams))
+	started := false
+	var hashed func() ([]joinGroup, error)
+	return func() ([]joinGroup, error) {
+		if hashed != nil {
+			return hashed()
+		}
+		if !started {
+			for _, s := range streams {
+				if err := s.advance(errCh); err != nil {
+					return nil, err
+				}
+			}
+			started = true
+		}
+
+		// find the smallest key among all active streams
+		var minKey string
+		found := false
+		for i, s := range streams {
+			if s.head == nil {
+				// no further matches are possible once a required file is done
+				if j.kind == innerJoin || (j.kind == leftJoin && i == 0) {
+					return nil, nil
+				}
+				continue
+			}
+			if !found || compareKeys(s.key, minKey) < 0 {
+				minKey = s.key
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+
+		unsorted := false
+		for i, s := range streams {
+			g := &groups[i]
+			g.key, g.rows, g.lines = s.key, g.rows[:0], g.lines[:0]
+			for s.head != nil && !s.unsorted && compareKeys(s.key, minKey) == 0 {
+				g.rows = append(g.rows, s.head)
+				g.lines = append(g.lines, s.line)
+				if err := s.advance(errCh); err != nil {
+					return nil, err
+				}
+			}
+			unsorted = unsorted || s.unsorted
+		}
+		if unsorted {
+			hashed = hashGroups(streams, groups, errCh, j)
+			return hashed()
+		}
+		return groups, nil
+	}
+}
+
+// hashGroups returns a function which reads the remaining rows of all input
+// files into memory and groups them by key. The rows of each file are taken
+// from its entry in seed, followed by the current row of its stream and all
+// rows which have not been received yet. Each call returns the rows of each
+// file with the next key or nil once all keys have been processed. Keys are
+// returned in the order in which they first appear in the input files.
+func hashGroups(streams []*keyedStream, seed []joinGroup, errCh <-chan error,
+	j joinSpec) func() ([]joinGroup, error) {
+
+	var tables []map[string]*joinGroup
+	var keys []string
+	next := 0
+	return func() ([]joinGroup, error) {
+		if tables == nil {
+			seen := make(map[string]bool)
+			tables = make([]map[string]*joinGroup, len(streams))
+
+			// add adds a row with the provided key to the table of file i
+			add := func(i int, key string, row []string, line int) {
+				name := normalizeKey(key)
+				g, ok := tables[i][name]
+				if !ok {
+					g = &joinGroup{key: key}
+					tables[i][name] = g
+				}
+				g.rows = append(g.rows, row)
+				g.lines = append(g.lines, line)
+				if !seen[name] && (i == 0 || j.kind == outerJoin) {
+					keys = append(keys, name)
+					seen[name] = true
+				}
+			}
+
+			for i, s := range streams {
+				tables[i] = make(map[string]*joinGroup)
+				if seed != nil {
+					for r, row := range seed[i].rows {
+						add(i, seed[i].key, row, seed[i].lines[r])
+					}
+				}
+				if s.head != nil {
+					add(i, s.key, s.head, s.line)
+				}
+				for {
+					cols, line, err := s.in.receive(errCh)
+					if err != nil {
+						return nil, err
+					} else if cols == nil {
+						break
+					}
+					add(i, cols[len(cols)-1], cols[:len(cols)-1], line)
+				}
+			}
+		}
+
+		if next >= len(keys) {
+			return nil, nil
+		}
+		groups := make([]joinGroup, len(tables))
+		for i, t := range tables {
+			if g, ok := t[keys[next]]; ok {
+				groups[i] = *g
+			}
+		}
+		next++
+		return groups, nil
+	}
+}
+
+// combineGroups assembles the output rows for the rows of all files sharing
+// a key. Rows of files with duplicate keys are combined in all possible ways.
+// Files without a matching row contribute columns set to the fill value if
+// the join type permits it, except for their key column which is set to the
+// key as written in the first file containing it. The input lines of each
+// output row are returned as well.
+func combineGroups(groups []joinGroup, j joinSpec) ([][]string, [][]int) {
+
+	var key string
+	for _, g := range groups {
+		if len(g.rows) != 0 {
+			key = g.key
+			break
+		}
+	}
+
+	numRows, width := 1, 0
+	for i, g := range groups {
+		if len(g.rows) == 0 {
+			if j.kind == innerJoin || (j.kind == leftJoin && i == 0) {
+				return nil, nil
+			}
+			fill := make([]string, j.widths[i])
+			for c := range fill {
+				fill[c] = j.fill
+			}
+			if j.keyPos[i] >= 0 {
+				fill[j.keyPos[i]] = key
+			}
+			groups[i] = joinGroup{rows: [][]string{fill}, lines: []int{0}}
+		}
+		numRows *= len(groups[i].rows)
+		width += len(groups[i].rows[0])
+	}
+
+	// the output rows and their lines share a single slice each and are
+	// enumerated with the rows of the last file changing fastest
+	rows := make([][]string, 0, numRows)
+	cells := make([]string, 0, numRows*width)
+	lines := make([][]int, 0, numRows)
+	rowLines := make([]int, 0, numRows*len(groups))
+	index := make([]int, len(groups))
+	for {
+		start := len(cells)
+		for i, g := range groups {
+			cells = append(cells, g.rows[index[i]]...)
+			rowLines = append(rowLines, g.lines[index[i]])
+		}
+		rows = append(rows, cells[start:len(cells):len(cells)])
+		lines = append(lines, rowLines[len(rowLines)-len(groups):len(rowLines):len(rowLines)])
+
+		i := len(groups) - 1
+		for ; i >= 0; i-- {
+			if index[i]++; index[i] < len(groups[i].rows) {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			return rows, lines
+		}
+	}
+}
+
+// compareKeys compares two keys numerically if both are numbers and
+// lexicographically otherwise. It returns -1, 0, or 1 if a is smaller, equal
+// or larger than b.
+func compareKeys(a, b string) int {
+	x, okX := numericKey(a)
+	y, okY := numericKey(b)
+	if !okX || !okY {
+		return strings.Compare(a, b)
+	}
+
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// normalizeKey converts numeric keys into a canonical representation so that
+// e.g. 1 and 1.0 are considered the same key
+func normalizeKey(key string) string {
+	if v, ok := numericKey(key); ok {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return key
+}
+
+// numericKey parses key as a number. NaN keys are not considered numbers
+// since they are not ordered and are compared as strings instead.
+func numericKey(key string) (float64, bool) {
+	v, err := strconv.ParseFloat(key, 64)
+	return v, err == nil && !math.IsNaN(v)
+}
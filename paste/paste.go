@@ -0,0 +1,1589 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+// Package paste implements the column extraction, pasting, joining and
+// statistics engine of the pst command line tool. A Pipeline is configured
+// via a Spec and processes a list of Inputs into an io.Writer or, via a
+// Reader, into a stream of output rows.
+package paste
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseSpec describes for each input files which columns to parse
+type ParseSpec []int
+
+// ComputeAction describes a computation to performed on row/column data
+type ComputeAction func([]float64) float64
+
+// ComputeSpec describes a list of computeActions to be performed on row/column data
+type ComputeSpec []ComputeAction
+
+// inputFile describes an opened input file together with its record reader
+// and, if requested, its header
+type inputFile struct {
+	name    string
+	file    io.Closer // the decompressor reading the input
+	records recordReader
+	header  []string
+	width   int // number of columns in the header or first record
+}
+
+// openInputs creates a recordReader for each of the provided inputs. Large
+// regular files are tokenized in chunks via newChunked unless it is nil.
+// Compressed inputs are decompressed transparently. If withHeader is set the
+// first record of each input is consumed as its header. The inputs are
+// opened concurrently so that slow decompressors or pipes do not delay each
+// other. The underlying readers are not closed by closeInputs and remain
+// owned by the caller.
+func openInputs(sources []Input, newReader, newChunked recordReaderFunc,
+	withHeader bool) ([]*inputFile, error) {
+
+	inputs := make([]*inputFile, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src Input) {
+			defer wg.Done()
+			inputs[i], errs[i] = openInput(src, newReader, newChunked, withHeader)
+		}(i, src)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, in := range inputs {
+				if in != nil {
+					in.file.Close()
+				}
+			}
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
+
+// openInput detects the compression of a single input, creates its
+// recordReader and reads its header or peeks at its first record
+func openInput(src Input, newReader, newChunked recordReaderFunc,
+	withHeader bool) (*inputFile, error) {
+
+	name := src.Name
+	data, err := decompress(src.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %s", name, err)
+	}
+	newRecords := newReader
+	if newChunked != nil && chunkable(src.Reader) {
+		newRecords = newChunked
+	}
+	in := &inputFile{name: name, file: data, records: newRecords(data)}
+	if c, ok := in.records.(io.Closer); ok {
+		// readers working in the background have to stop before the input
+		in.file = closerList{c, data}
+	}
+
+	if withHeader {
+		if in.header, err = in.records.Read(); err != nil {
+			in.file.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("file %s is empty and has no header", name)
+			}
+			return nil, fmt.Errorf("error reading header of file %s: %s", name, err)
+		}
+		in.width = len(in.header)
+	} else {
+		// peek at the first record to determine the number of columns.
+		// Errors are reported once the record is read again.
+		p := &peekedReader{recordReader: in.records}
+		p.first, p.err = in.records.Read()
+		in.width = len(p.first)
+		in.records = p
+	}
+	return in, nil
+}
+
+// peekedReader is a recordReader returning an already read first record
+// before continuing with the underlying reader
+type peekedReader struct {
+	recordReader
+	first []string
+	err   error
+	done  bool
+}
+
+// Read returns the peeked record on the first call and subsequently reads
+// from the underlying recordReader
+func (p *peekedReader) Read() ([]string, error) {
+	if !p.done {
+		p.done = true
+		return p.first, p.err
+	}
+	return p.recordReader.Read()
+}
+
+// inputWidths returns the number of columns of each input file
+func inputWidths(inputs []*inputFile) []int {
+	widths := make([]int, len(inputs))
+	for i, in := range inputs {
+		widths[i] = in.width
+	}
+	return widths
+}
+
+// closerList closes all its entries in order
+type closerList []io.Closer
+
+// Close closes all entries and returns the first error encountered
+func (cl closerList) Close() error {
+	var err error
+	for _, c := range cl {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// closeInputs closes all provided input files
+func closeInputs(inputs []*inputFile) {
+	for _, in := range inputs {
+		in.file.Close()
+	}
+}
+
+// getColumnNames returns the header names of all columns extracted from the
+// input files according to inCols. Names which are extracted from more than
+// one file are prefixed with their file name, i.e. "file:name".
+func getColumnNames(inputs []*inputFile, inCols []ParseSpec) ([]string, error) {
+	var names []string
+	var files []int
+	for i, in := range inputs {
+		if len(inCols[i]) == 0 {
+			names = append(names, in.header...)
+			for range in.header {
+				files = append(files, i)
+			}
+			continue
+		}
+		for _, c := range inCols[i] {
+			if c >= len(in.header) {
+				return nil, fmt.Errorf("header of file %s has no column %d", in.name, c)
+			}
+			names = append(names, in.header[c])
+			files = append(files, i)
+		}
+	}
+
+	// find the files each name occurs in
+	nameFiles := make(map[string]map[int]bool)
+	for i, n := range names {
+		if nameFiles[n] == nil {
+			nameFiles[n] = make(map[int]bool)
+		}
+		nameFiles[n][files[i]] = true
+	}
+	for i, n := range names {
+		if len(nameFiles[n]) > 1 {
+			names[i] = inputs[files[i]].name + ":" + n
+		}
+	}
+	return names, nil
+}
+
+// getOutputHeader assembles the header line of the output from the names of
+// the extracted columns ordered according to outCols. If compute actions are
+// requested the header consists of the action names instead or, if
+// appendCols is set, the action names follow the column names.
+func getOutputHeader(names []string, outCols ParseSpec, actions string,
+	appendCols bool) []string {
+
+	var header []string
+	if actions == "" || appendCols {
+		if len(outCols) == 0 {
+			header = append(header, names...)
+		} else {
+			for _, c := range outCols {
+				header = append(header, names[c])
+			}
+		}
+	}
+
+	return append(header, actionNames(actions)...)
+}
+
+// parseData parses each of the opened input files in a separate goroutine.
+// The done channel used to signal each goroutine to shut down. The errCh
+// channel signals any file parsing issues back to the calling function.
+// If a joinSpec is provided the rows are joined by key, otherwise they are
+// pasted in order according to the pasteSpec.
+func parseData(inputs []*inputFile, inCols []ParseSpec, rowRanges []rowRange,
+	paste pasteSpec, join *joinSpec, out outputSpec, output rowWriter) error {
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errCh := make(chan error, len(inputs))
+	defer close(errCh)
+
+	var streams []*rowStream
+	for i, in := range inputs {
+		dataCh := make(chan *rowBatch, 16) // use buffered channels to not stall IO
+		// pasted rows are copied right away so their batches can be reused
+		streams = append(streams, &rowStream{ch: dataCh, recycle: join == nil})
+		wg.Add(1)
+		keyCol := -1
+		if join != nil {
+			keyCol = join.keys[i]
+		}
+		go fileParser(in, inCols[i], rowRanges, keyCol, dataCh, done, errCh, &wg)
+	}
+
+	next := pasteRows(streams, errCh, paste)
+	if join != nil {
+		next = joinRows(streams, errCh, *join)
+	}
+	err := processData(next, out, output)
+	close(done)
+	wg.Wait()
+
+	return err
+}
+
+// rowSource returns the next assembled input row consisting of the extracted
+// columns of all input files together with the line of each input file the
+// row was taken from, 0 for files which did not contribute. A nil row
+// signals the end of the data.
+type rowSource func() ([]string, []int, error)
+
+// outputSpec describes how the assembled rows are turned into output
+type outputSpec struct {
+	cols    ParseSpec      // output column order
+	exprs   []expr         // expressions computing the output columns
+	filter  expr           // only rows for which filter is true are output
+	header  []string       // header line, not printed if empty
+	sep     string         // output column separator
+	format  outputFormat   // output format of the rows
+	actions ComputeSpec    // row wise compute actions
+	formats []numberFormat // number format for each compute action
+	missing missingSpec    // handling of missing values by compute actions
+	rolling *rollingSpec   // windowed statistics appended to each row
+	groups  *groupSpec     // group-by aggregation of the rows
+	summary *columnSummary
+
+	// print the output columns followed by the computed values
+	appendCols bool
+}
+
+// processData pulls the assembled rows from the row source and writes them
+// to output. A non-empty header is printed before the first row. If a column
+// summary is requested only the summary rows are printed at the end.
+func processData(next rowSource, out outputSpec, output rowWriter) error {
+
+	outRow := make([]string, len(out.cols))
+	defer output.flush()
+	if len(out.header) > 0 {
+		if err := output.writeHeader(out.header); err != nil {
+			return err
+		}
+	}
+	for {
+		inRow, lines, err := next()
+		if err != nil {
+			return err
+		} else if inRow == nil {
+			break
+		}
+
+		if out.filter != nil {
+			keep, err := out.filter(inRow)
+			if err != nil {
+				return fmt.Errorf("error evaluating filter in %s: %s",
+					out.missing.rowPosition(lines), err)
+			} else if !keep.truth() {
+				continue
+			}
+		}
+
+		// assemble output based on expressions or outCols if requested
+		if out.exprs != nil {
+			outRow = outRow[:0]
+			for i, e := range out.exprs {
+				v, err := e(inRow)
+				if err != nil {
+					return fmt.Errorf("error evaluating expression #%d in %s: %s", i,
+						out.missing.rowPosition(lines), err)
+				}
+				outRow = append(outRow, v.String())
+			}
+		} else if len(out.cols) == 0 {
+			outRow = inRow
+		} else {
+			for i, c := range out.cols {
+				outRow[i] = inRow[c]
+			}
+		}
+
+		fullRow := outRow
+		if out.rolling != nil {
+			if fullRow, err = out.rolling.add(outRow, lines, out.missing); err != nil {
+				return err
+			}
+		}
+
+		if out.groups != nil {
+			err = out.groups.add(output, fullRow, lines)
+		} else if out.summary != nil {
+			err = out.summary.add(fullRow, lines)
+		} else {
+			err = printRow(output, fullRow, lines, out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if out.groups != nil {
+		err = out.groups.flush(output)
+	} else if out.summary != nil {
+		err = out.summary.print(output)
+	}
+	if err != nil {
+		return err
+	}
+	return output.flush()
+}
+
+// unevenPolicy describes how input files with different numbers of rows
+// are pasted
+type unevenPolicy int
+
+const (
+	padRows      unevenPolicy = iota // pad files which ended early
+	shortestRows                     // stop once the first file ends
+	strictRows                       // files ending early are an error
+)
+
+// pasteSpec describes how rows of the input files are pasted
+type pasteSpec struct {
+	policy unevenPolicy
+	fill   string   // fill value for files which ended early
+	widths []int    // number of extracted columns per file, 0 if not known
+	names  []string // file names for error reporting
+}
+
+// getPasteSpec parses the uneven row policy and assembles the pasteSpec for
+// the input files
+func getPasteSpec(policy, fill string, inputs []*inputFile,
+	inCols []ParseSpec) (pasteSpec, error) {
+
+	p := pasteSpec{fill: fill}
+	switch strings.TrimSpace(policy) {
+	case "", "pad":
+		p.policy = padRows
+	case "shortest":
+		p.policy = shortestRows
+	case "strict":
+		p.policy = strictRows
+	default:
+		return p, fmt.Errorf("unknown policy %s for files with different numbers "+
+			"of rows, expected one of pad, shortest, or strict", policy)
+	}
+
+	for i, in := range inputs {
+		p.widths = append(p.widths, len(inCols[i]))
+		p.names = append(p.names, in.name)
+	}
+	return p, nil
+}
+
+// pasteRows returns a rowSource which assembles rows by pasting row N of
+// every input file next to row N of all other input files. Files which end
+// early are handled according to the policy of the pasteSpec.
+func pasteRows(streams []*rowStream, errCh <-chan error, p pasteSpec) rowSource {
+
+	var inRow []string
+	fillRows := make([][]string, len(streams))
+	rowCols := make([][]string, len(streams))
+	lines := make([]int, len(streams))
+	last := make([]int, len(streams)) // line of the previous row of each file
+	row := 0
+	return func() ([]string, []int, error) {
+		// process each stream to read the column entries for the current row
+		ended := -1
+		active := 0
+		for i, s := range streams {
+			cols, line, err := s.receive(errCh)
+			if err != nil {
+				return nil, nil, err
+			}
+			rowCols[i], lines[i] = cols, line
+			if cols != nil {
+				active++
+				last[i] = line
+			} else if ended == -1 {
+				ended = i
+			}
+		}
+
+		if active == 0 {
+			return nil, nil, nil // all channels are done reading so we're done, too
+		} else if ended != -1 {
+			switch p.policy {
+			case shortestRows:
+				return nil, nil, nil
+			case strictRows:
+				return nil, nil, fmt.Errorf("file %s ended after line %d while other "+
+					"files continue with %s", p.names[ended], last[ended],
+					linePosition(p.names, lines))
+			}
+		}
+
+		// When we hit the first row we initialize the inRow array and the fill
+		// rows. For all subsequent rows we can recycle it for efficiency (UGLY
+		// I know)
+		if row == 0 {
+			for i, cols := range rowCols {
+				width := p.widths[i]
+				if width == 0 {
+					width = len(cols)
+				}
+				fillRows[i] = make([]string, width)
+				for c := range fillRows[i] {
+					fillRows[i][c] = p.fill
+				}
+			}
+		}
+
+		var in int
+		for i, cols := range rowCols {
+			if cols == nil {
+				cols = fillRows[i]
+			}
+			if row == 0 {
+				inRow = append(inRow, cols...)
+			} else {
+				for _, c := range cols {
+					inRow[in] = c
+					in++
+				}
+			}
+		}
+		row++
+		return inRow, lines, nil
+	}
+}
+
+// batchSize is the number of rows file parsers send down their data channel
+// at once
+const batchSize = 512
+
+// rowBatch is a block of consecutive rows of an input file. The extracted
+// columns of all rows share the cells buffer.
+type rowBatch struct {
+	rows  [][]string
+	lines []int // line of the input file of each row
+	cells []string
+}
+
+// batchPool recycles rowBatches whose rows have been consumed
+var batchPool = sync.Pool{New: func() interface{} { return new(rowBatch) }}
+
+// newBatch returns an empty rowBatch from the pool
+func newBatch() *rowBatch {
+	b := batchPool.Get().(*rowBatch)
+	b.rows = b.rows[:0]
+	b.lines = b.lines[:0]
+	b.cells = b.cells[:0]
+	return b
+}
+
+// rowStream delivers the rows of an input file one at a time from the
+// rowBatches sent by its file parser. If recycle is set the rows are not
+// retained by the caller and consumed batches are returned to the pool.
+type rowStream struct {
+	ch      <-chan *rowBatch
+	batch   *rowBatch
+	pos     int
+	recycle bool
+}
+
+// receive returns the next row of the stream and its line in the input file
+// or nil if its data channel is closed. Errors reported by the file parsers
+// are returned as well.
+func (s *rowStream) receive(errCh <-chan error) ([]string, int, error) {
+	for s.batch == nil || s.pos >= len(s.batch.rows) {
+		if s.batch != nil && s.recycle {
+			batchPool.Put(s.batch)
+		}
+		s.batch, s.pos = nil, 0
+		select {
+		case b := <-s.ch:
+			if b == nil {
+				// file parsers report errors before closing their data channel
+				select {
+				case err := <-errCh:
+					return nil, 0, err
+				default:
+				}
+				return nil, 0, nil
+			}
+			s.batch = b
+		case err := <-errCh:
+			return nil, 0, err
+		}
+	}
+	s.pos++
+	return s.batch.rows[s.pos-1], s.batch.lines[s.pos-1], nil
+}
+
+// printRow creates output based on the provided row. If compute actions are
+// provided they will be performed and their results printed in the requested
+// number format, following the row itself if appendCols is set. Otherwise
+// the row will be printed as is. The input lines of the row are used for
+// error reporting.
+func printRow(output rowWriter, outRow []string, lines []int, out outputSpec) error {
+
+	if len(out.actions) > 0 {
+		items, err := splitIntoFloats(outRow, out.missing, lines)
+		if err != nil {
+			return err
+		}
+		if out.missing.policy == skipMissing {
+			items = dropNaN(items)
+		}
+		var results []string
+		if out.appendCols {
+			results = make([]string, len(outRow), len(outRow)+len(out.actions))
+			copy(results, outRow)
+		}
+		for i, a := range out.actions {
+			results = append(results, out.formats[i](a(items)))
+		}
+		outRow = results
+	}
+
+	return output.writeRow(outRow)
+}
+
+// fileParser parses the input file record by record and sends the requested
+// columns down the data channel in batches of rows. It closes the file when
+// done. If keyCol is not negative the content of the key column is appended
+// to each row. If it receives on the done channel it stops processing and
+// returns
+func fileParser(in *inputFile, colSpec ParseSpec, rowRanges rowRangeSlice,
+	keyCol int, data chan<- *rowBatch, done <-chan struct{}, errCh chan<- error,
+	wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	defer close(data)
+	defer in.file.Close()
+
+	fileName := in.name
+	records := in.records
+	maxRow := rowRanges.maxEntry()
+	// line of the first data row
+	first := 1
+	if in.header != nil {
+		first = 2
+	}
+
+	// send passes the current batch down the data channel and returns false
+	// if processing should stop
+	batch := newBatch()
+	send := func() bool {
+		if len(batch.rows) == 0 {
+			return true
+		}
+		select {
+		case data <- batch:
+		case <-done:
+			return false
+		}
+		batch = newBatch()
+		return true
+	}
+
+	// extract adds the requested columns of the given data row to the current
+	// batch and returns false if processing should stop
+	extract := func(items []string, row int) bool {
+		if keyCol >= len(items) {
+			errCh <- fmt.Errorf("error parsing file %s: key column %d "+
+				"does not exist", fileName, keyCol)
+			return false
+		}
+
+		cols := items
+		// an empty colSpec signals all rows
+		if len(colSpec) == 0 {
+			if keyCol >= 0 {
+				cols = append(cols, items[keyCol])
+			}
+		} else {
+			start := len(batch.cells)
+			for _, c := range colSpec {
+				if c >= len(items) {
+					errCh <- fmt.Errorf("error parsing file %s: requested column %d "+
+						"does not exist", fileName, c)
+					return false
+				}
+				batch.cells = append(batch.cells, items[c])
+			}
+			if keyCol >= 0 {
+				batch.cells = append(batch.cells, items[keyCol])
+			}
+			end := len(batch.cells)
+			cols = batch.cells[start:end:end]
+		}
+
+		batch.rows = append(batch.rows, cols)
+		batch.lines = append(batch.lines, first+row)
+		if len(batch.rows) == batchSize {
+			return send()
+		}
+		return true
+	}
+
+	// rows referred to by negative indices are only known once the end of
+	// the file is reached. We therefore hold back as many records as the
+	// largest negative index before deciding if a row is requested.
+	lookahead := rowRanges.lookahead()
+	var pending [][]string
+	count := 0 // row number of the first pending record
+	for {
+		items, err := records.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			errCh <- fmt.Errorf("error parsing file %s: %s", fileName, err)
+			return
+		}
+
+		if lookahead > 0 {
+			pending = append(pending, items)
+			if len(pending) <= lookahead {
+				continue
+			}
+			items, pending = pending[0], pending[1:]
+		}
+		row := count
+		count++
+
+		// logic for only printing requested rows
+		if row > maxRow {
+			send()
+			return
+		}
+		if !rowRanges.contains(row) {
+			continue
+		}
+		if !extract(items, row) {
+			return
+		}
+	}
+
+	// the number of rows is known now
+	numRows := count + len(pending)
+	for i, items := range pending {
+		if count+i > maxRow {
+			break
+		}
+		if !rowRanges.containsRow(count+i, numRows) {
+			continue
+		}
+		if !extract(items, count+i) {
+			return
+		}
+	}
+	send()
+}
+
+// recordReader reads the records of an input file one at a time and returns
+// them split into their fields. At the end of the input Read returns io.EOF.
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// recordReaderFunc creates a recordReader for an input stream
+type recordReaderFunc func(io.Reader) recordReader
+
+// lineBlockSize is the approximate number of bytes a lineReader reads and
+// tokenizes at once
+const lineBlockSize = 64 * 1024
+
+// lineReader is a recordReader for line oriented files. Each line is split
+// into fields according to sepFun unless whole is set in which case the
+// complete line is returned as a single field. Lines are read and tokenized
+// in blocks whose fields share a single string and slice to avoid per line
+// allocations.
+type lineReader struct {
+	input   *bufio.Reader
+	sepFun  func(rune) bool
+	whole   bool
+	maxLine int // maximum line length in bytes, unlimited if 0
+	line    int // number of lines read so far
+
+	block   []byte     // content of the lines of the current block
+	ends    []int      // end offset of each line within block
+	records [][]string // records of the current block
+	pos     int
+	err     error
+}
+
+// newLineReader creates a lineReader for lines of up to maxLine bytes or of
+// any length if maxLine is 0
+func newLineReader(r io.Reader, sepFun func(rune) bool, whole bool,
+	maxLine int) *lineReader {
+
+	return &lineReader{input: bufio.NewReaderSize(r, lineBlockSize), sepFun: sepFun,
+		whole: whole, maxLine: maxLine}
+}
+
+// Read returns the fields of the next line
+func (l *lineReader) Read() ([]string, error) {
+	for l.pos >= len(l.records) {
+		if l.err != nil {
+			return nil, l.err
+		}
+		l.readBlock()
+	}
+	l.pos++
+	return l.records[l.pos-1], nil
+}
+
+// readBlock reads and tokenizes the next block of lines. It stops early once
+// no more input is buffered so that lines arriving slowly, e.g. via a pipe,
+// are passed on without delay. Errors are returned by Read after the lines
+// preceding them.
+func (l *lineReader) readBlock() {
+
+	l.block, l.ends = l.block[:0], l.ends[:0]
+	for len(l.block) < lineBlockSize {
+		start := len(l.block)
+		data, err := l.input.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			l.block = append(l.block, data...)
+			// stop reading lines which can not fit, allowing for a "\r"
+			if l.maxLine > 0 && len(l.block)-start > l.maxLine+1 {
+				l.err = lineTooLong(l.line+1, l.maxLine)
+				break
+			}
+			data, err = l.input.ReadSlice('\n')
+		}
+		if l.err != nil {
+			l.block = l.block[:start]
+			break
+		}
+		l.block = append(l.block, data...)
+
+		// drop the line ending like bufio.ScanLines
+		end := len(l.block)
+		if end > start && l.block[end-1] == '\n' {
+			end--
+		}
+		if end > start && l.block[end-1] == '\r' {
+			end--
+		}
+		l.block = l.block[:end]
+		if err == nil || end > start {
+			l.line++
+			if l.maxLine > 0 && end-start > l.maxLine {
+				l.block = l.block[:start]
+				l.err = lineTooLong(l.line, l.maxLine)
+				break
+			}
+			l.ends = append(l.ends, end)
+		}
+		if err != nil {
+			l.err = err
+			break
+		}
+		if l.input.Buffered() == 0 {
+			break
+		}
+	}
+
+	content := string(l.block)
+	// the previous block provides an estimate of the number of fields per line
+	width := 1
+	if n := len(l.records); n > 0 && len(l.records[n-1]) > 0 {
+		width = len(l.records[n-1])
+	}
+	// only the fields are passed on, the list of records can be reused
+	l.records = l.records[:0]
+	cells := make([]string, 0, width*len(l.ends))
+	start := 0
+	for _, end := range l.ends {
+		first := len(cells)
+		cells = appendFields(cells, content[start:end], l.sepFun, l.whole)
+		l.records = append(l.records, cells[first:len(cells):len(cells)])
+		start = end
+	}
+	l.pos = 0
+}
+
+// lineTooLong returns the error for a line exceeding the maximum line length
+func lineTooLong(line, maxLine int) error {
+	return fmt.Errorf("line %d exceeds the maximum line length of %d bytes", line, maxLine)
+}
+
+// appendFields appends the fields of line to cells. Fields are separated
+// according to sepFun or at whitespace if sepFun is nil. If whole is set the
+// complete line is appended as a single field.
+func appendFields(cells []string, line string, sepFun func(rune) bool,
+	whole bool) []string {
+
+	if whole {
+		return append(cells, line)
+	} else if sepFun != nil {
+		line = strings.TrimSpace(line)
+	}
+
+	start := -1 // start of the current field or -1 between fields
+	for i := 0; i < len(line); {
+		var sep bool
+		r, size := rune(line[i]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRuneInString(line[i:])
+		}
+		if sepFun != nil {
+			sep = sepFun(r)
+		} else if r < utf8.RuneSelf {
+			sep = r == ' ' || (r >= '\t' && r <= '\r')
+		} else {
+			sep = unicode.IsSpace(r)
+		}
+		if !sep {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			cells = append(cells, line[start:i])
+			start = -1
+		}
+		i += size
+	}
+	if start >= 0 {
+		cells = append(cells, line[start:])
+	}
+	return cells
+}
+
+// getRecordReaderFunc returns a closure creating the recordReader used for
+// splitting the input files into records and fields. In csv mode inputSep
+// has to be a single character; whole requests complete lines in line mode.
+// Lines longer than maxLine bytes are rejected in line mode unless maxLine
+// is 0.
+func getRecordReaderFunc(inputSep string, csvMode, whole bool,
+	maxLine int) (recordReaderFunc, error) {
+
+	if maxLine < 0 {
+		return nil, fmt.Errorf("invalid maximum line length %d", maxLine)
+	}
+	if !csvMode {
+		sepFun := getInputSepFunc(inputSep)
+		return func(r io.Reader) recordReader {
+			return newLineReader(r, sepFun, whole, maxLine)
+		}, nil
+	}
+
+	comma := ','
+	if inputSep != "" {
+		if utf8.RuneCountInString(inputSep) != 1 {
+			return nil, fmt.Errorf("csv separator %q has to be a single character", inputSep)
+		}
+		comma, _ = utf8.DecodeRuneInString(inputSep)
+		if comma == '"' || comma == '\r' || comma == '\n' {
+			return nil, fmt.Errorf("invalid csv separator %q", inputSep)
+		}
+	}
+	return func(r io.Reader) recordReader {
+		c := csv.NewReader(r)
+		c.Comma = comma
+		// when returning all fields every record has to have the same length
+		c.FieldsPerRecord = -1
+		if whole {
+			c.FieldsPerRecord = 0
+		}
+		return c
+	}, nil
+}
+
+// getInputSpec parses, checks, and the returns the inputSpecs. If headers
+// are provided, column names are resolved against the header of each file.
+// Open ranges and negative indices are resolved against the number of
+// columns of each file given by widths.
+// NOTE: We pad the list of parseSpecs with the final supplied entry if there
+// are more files than provided spec entries
+func getInputSpec(input string, numFiles int, headers [][]string,
+	widths []int) ([]ParseSpec, error) {
+	var inCols []ParseSpec
+	var err error
+	if input == "" {
+		// an empty ParseSpec per file selects all columns
+		return make([]ParseSpec, numFiles), err
+	}
+
+	if inCols, err = parseInputSpec(input, headers, widths); err != nil {
+		return inCols, err
+	}
+	if len(inCols) > numFiles {
+		return inCols, fmt.Errorf("there are more per file column specifiers than supplied input files")
+	}
+	finalSpec := inCols[len(inCols)-1]
+	pading := numFiles - len(inCols)
+	for i := 0; i < pading; i++ {
+		inCols = append(inCols, finalSpec)
+	}
+	return inCols, err
+}
+
+// parseInputSpec parses the inputSpec and turns it into a slice of parseSpecs,
+// one for each input file. An empty inputSpec is assumed to imply that the
+// user wants to grab all columns in each file.
+// If headers are provided column names are resolved against the header of the
+// corresponding file. Similarly, open ranges and negative indices are
+// resolved against the number of columns of each file given by widths. Since
+// both can map to different indices in each file the spec is then padded to
+// one entry per file.
+func parseInputSpec(input string, headers [][]string, widths []int) ([]ParseSpec, error) {
+
+	if len(input) == 0 {
+		return []ParseSpec{ParseSpec{}}, nil
+	}
+
+	// split according to file specs
+	fileSpecs := strings.Split(input, "|")
+	for len(headers) > len(fileSpecs) || len(widths) > len(fileSpecs) {
+		fileSpecs = append(fileSpecs, fileSpecs[len(fileSpecs)-1])
+	}
+
+	spec := make([]ParseSpec, len(fileSpecs))
+	// split according to column specs
+	for i, f := range fileSpecs {
+		if strings.TrimSpace(f) == "" {
+			return nil, fmt.Errorf("empty input specification for file entry #%d", i)
+		}
+
+		var header []string
+		if i < len(headers) {
+			header = headers[i]
+		}
+		width := -1
+		if i < len(widths) {
+			width = widths[i]
+		}
+		ps, err := parseColumnList(f, header, width)
+		if err != nil {
+			return nil, fmt.Errorf("input specification for file entry #%d: %s", i, err)
+		}
+		spec[i] = ps
+	}
+	return spec, nil
+}
+
+// getOutputSpec parses, checks and then returns the outputSpecs. If names
+// are provided output columns can be selected by name. inCols and inputs
+// are used to resolve columns qualified by their input file.
+func getOutputSpec(output string, numCols int, names []string, inCols []ParseSpec,
+	inputs []*inputFile) (ParseSpec, error) {
+
+	var outCols ParseSpec
+	var err error
+	if output == "" {
+		return outCols, err
+	}
+
+	if outCols, err = parseOutputSpec(output, names, numCols, inCols, inputs); err != nil {
+		return outCols, err
+	}
+
+	min, max := outCols.minMax()
+	if max >= numCols || min < 0 {
+		return outCols, fmt.Errorf("at least one output column specifier is out of bounds or negative %d %d %d", min, max, numCols)
+	}
+
+	return outCols, nil
+}
+
+// parseOutputSpec parses the comma separated list of output columns. If names
+// are provided columns can be given by name. Open ranges and negative indices
+// refer to the numCols output columns.
+// Entries of the form "file:columns", e.g. "2:1" or "0:3-5", select columns
+// of the given input file instead and are resolved against the per file
+// input specs inCols.
+func parseOutputSpec(input string, names []string, numCols int, inCols []ParseSpec,
+	inputs []*inputFile) (ParseSpec, error) {
+
+	var spec ParseSpec
+	for _, entry := range strings.Split(input, ",") {
+		var cols ParseSpec
+		var err error
+		if file, fileCols, ok := splitQualified(entry, names); ok {
+			cols, err = parseQualifiedColumns(file, fileCols, inCols, inputs)
+		} else {
+			cols, err = parseColumnList(entry, names, numCols)
+		}
+		if err != nil {
+			return nil, err
+		}
+		spec = append(spec, cols...)
+	}
+	return spec, nil
+}
+
+// splitQualified splits an output column entry of the form "file:columns"
+// into the file index and its column list. Entries matching one of the
+// column names are not treated as qualified.
+func splitQualified(entry string, names []string) (int, string, bool) {
+	entry = strings.TrimSpace(entry)
+	i := strings.Index(entry, ":")
+	if i <= 0 {
+		return 0, "", false
+	}
+	file, err := strconv.Atoi(entry[:i])
+	if err != nil || file < 0 {
+		return 0, "", false
+	}
+	for _, n := range names {
+		if n == entry {
+			return 0, "", false
+		}
+	}
+	return file, entry[i+1:], true
+}
+
+// parseQualifiedColumns converts the column list of input file #file into
+// indices of the columns extracted from all files. Columns refer to the
+// columns of the input file and have to be selected by its input spec. If
+// inputs are provided columns can be given by name and open ranges and
+// negative indices are resolved against the number of columns of the file.
+func parseQualifiedColumns(file int, cols string, inCols []ParseSpec,
+	inputs []*inputFile) (ParseSpec, error) {
+
+	if file >= len(inCols) {
+		return nil, fmt.Errorf("output column %d:%s refers to file #%d but there are "+
+			"only %d input files", file, cols, file, len(inCols))
+	}
+
+	var header []string
+	width := -1
+	if inputs != nil {
+		header = inputs[file].header
+		width = inputs[file].width
+	}
+	fileCols, err := parseColumnList(cols, header, width)
+	if err != nil {
+		return nil, fmt.Errorf("output column %d:%s: %s", file, cols, err)
+	}
+
+	offset := totalLen(inCols[:file])
+	spec := make(ParseSpec, len(fileCols))
+	for i, c := range fileCols {
+		index := -1
+		for j, ic := range inCols[file] {
+			if ic == c {
+				index = j
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("output column %d:%s refers to column %d of file #%d "+
+				"which is not extracted by the input spec", file, cols, c, file)
+		}
+		spec[i] = offset + index
+	}
+	return spec, nil
+}
+
+// parseColumnList parses a comma separated list of columns and column ranges.
+// If a header is provided, entries which are not numeric are looked up by
+// name. Unknown names result in an error listing the available names.
+// Open ranges and negative indices are resolved against the number of
+// columns numCols which is negative if unknown.
+func parseColumnList(input string, header []string, numCols int) (ParseSpec, error) {
+
+	var spec ParseSpec
+	for _, cr := range strings.Split(input, ",") {
+		c := strings.TrimSpace(cr)
+		r, err := parseRange(c)
+		if err != nil {
+			if header == nil {
+				return nil, err
+			}
+			if r.b, err = lookupColumn(c, header); err != nil {
+				return nil, err
+			}
+			r.e = r.b
+		}
+
+		begin, end, ok := r.resolve(numCols)
+		if !ok {
+			return nil, fmt.Errorf("column range %s requires the number of columns "+
+				"which is unknown", c)
+		}
+		if begin < 0 || end < begin {
+			return nil, fmt.Errorf("column range %s is empty or out of bounds for "+
+				"%d columns", c, numCols)
+		}
+		spec = append(spec, makeIntRange(begin, end, r.step)...)
+	}
+	return spec, nil
+}
+
+// lookupColumn returns the index of the column called name in header
+func lookupColumn(name string, header []string) (int, error) {
+	index := -1
+	for i, h := range header {
+		if h != name {
+			continue
+		}
+		if index != -1 {
+			return index, fmt.Errorf("column name %q is ambiguous, it appears at "+
+				"positions %d and %d", name, index, i)
+		}
+		index = i
+	}
+	if index == -1 {
+		return index, fmt.Errorf("unknown column name %q, available columns are: %s",
+			name, strings.Join(header, ", "))
+	}
+	return index, nil
+}
+
+// getRowSpec parses, checks, and returns the rowSpecs
+func getRowSpec(rows string) ([]rowRange, error) {
+
+	var rowRanges rowRangeSlice
+	var err error
+	if rows == "" {
+		return rowRanges, err
+	}
+
+	if rowRanges, err = parseRowSpec(rows); err != nil {
+		return rowRanges, err
+	}
+	sort.Sort(rowRanges)
+	return rowRanges, nil
+}
+
+// parseRowSpec parses the comma separated list of row ranges to output
+func parseRowSpec(input string) ([]rowRange, error) {
+
+	rowSpecs := strings.Split(input, ",")
+	rowRanges := make([]rowRange, len(rowSpecs))
+	for i, r := range rowSpecs {
+		rr, err := parseRange(strings.TrimSpace(r))
+		if err != nil {
+			return nil, err
+		}
+		rowRanges[i] = rr
+	}
+	return rowRanges, nil
+}
+
+// getComputeSpecs parses, checks and returns the compute actions to be
+// performed on the data set
+func getComputeSpecs(actions string) (ComputeSpec, error) {
+
+	var specs ComputeSpec
+	if actions == "" {
+		return specs, nil
+	}
+	return parseComputeSpec(actions)
+}
+
+// rangeRegexp matches ranges of the form "a-b" where either end can be
+// omitted and may be negative
+var rangeRegexp = regexp.MustCompile(`^(-?[0-9]+)?-(-?[0-9]+)?$`)
+
+// parseRange parses a range string of the form "a", "a-b", "a-", or "-b"
+// with an optional step suffix ":s" as in "0-1000:10". An omitted beginning
+// refers to the first and an omitted end to the last entry. Negative indices
+// count from the end, i.e. -1 is the last entry. Since "-b" denotes an open
+// beginning a single negative index has to be given as range, e.g. "-1--1".
+func parseRange(input string) (rowRange, error) {
+
+	r := rowRange{step: 1}
+	if i := strings.Index(input, ":"); i != -1 {
+		step, err := strconv.Atoi(input[i+1:])
+		if err != nil || step < 1 {
+			return r, fmt.Errorf("invalid step in range specification %s, expected a "+
+				"positive integer", input)
+		}
+		r.step = step
+		input = input[:i]
+	}
+
+	var err error
+	if m := rangeRegexp.FindStringSubmatch(input); m != nil {
+		if m[1] == "" && m[2] == "" {
+			return r, fmt.Errorf("incorrect range specification %s", input)
+		}
+		if m[1] != "" {
+			r.b, err = strconv.Atoi(m[1])
+		}
+		if m[2] == "" {
+			r.open = true
+		} else if err == nil {
+			r.e, err = strconv.Atoi(m[2])
+		}
+	} else {
+		r.b, err = strconv.Atoi(input)
+		if err == nil && r.b < 0 {
+			err = fmt.Errorf("negative index")
+		}
+		r.e = r.b
+	}
+	if err != nil {
+		return r, fmt.Errorf("could not convert %s into integer representation", input)
+	}
+
+	if r.b >= 0 && r.e >= 0 && !r.open && r.e < r.b {
+		return r, fmt.Errorf("the end of interval %s is smaller than its beginning", input)
+	}
+	return r, nil
+}
+
+// missingPolicy describes how compute actions handle missing and
+// non-numeric values
+type missingPolicy int
+
+const (
+	failMissing    missingPolicy = iota // missing values are an error
+	skipMissing                         // missing values and NaN are ignored
+	nanMissing                          // missing values are NaN
+	replaceMissing                      // missing values are replaced by a constant
+)
+
+// missingSpec describes the handling of missing values by compute actions.
+// The remaining fields describe the origin of the output columns for error
+// reporting.
+type missingSpec struct {
+	policy  missingPolicy
+	value   float64  // replacement value
+	origins []string // file and column of each output column
+	files   []int    // input file of each output column, -1 if not known
+	names   []string // names of the input files
+}
+
+// getMissingSpec parses the missing value policy and determines the origin
+// of each output column for error reporting
+func getMissingSpec(policy string, inputs []*inputFile, inCols []ParseSpec,
+	outCols ParseSpec) (missingSpec, error) {
+
+	var m missingSpec
+	switch p := strings.TrimSpace(policy); p {
+	case "", "fail":
+		m.policy = failMissing
+	case "skip":
+		m.policy = skipMissing
+	case "nan":
+		m.policy = nanMissing
+	default:
+		value, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return m, fmt.Errorf("unknown missing value policy %s, expected one of "+
+				"fail, skip, nan, or a number", policy)
+		}
+		m.policy = replaceMissing
+		m.value = value
+	}
+
+	var origins []string
+	var files []int
+	for i, in := range inputs {
+		m.names = append(m.names, in.name)
+		if len(inCols[i]) == 0 {
+			origins = append(origins, "file "+in.name)
+			files = append(files, i)
+		}
+		for _, c := range inCols[i] {
+			origins = append(origins, fmt.Sprintf("file %s column %d", in.name, c))
+			files = append(files, i)
+		}
+	}
+	if len(outCols) == 0 {
+		m.origins, m.files = origins, files
+	} else {
+		for _, c := range outCols {
+			m.origins = append(m.origins, origins[c])
+			m.files = append(m.files, files[c])
+		}
+	}
+	return m, nil
+}
+
+// rowPosition describes the input lines a row was taken from for error
+// reporting, e.g. "line 3 of file a, line 5 of file b"
+func (m missingSpec) rowPosition(lines []int) string {
+	return linePosition(m.names, lines)
+}
+
+// linePosition describes the lines of the named input files a row was taken
+// from, e.g. "line 3 of file a, line 5 of file b". Files with line 0 did not
+// contribute to the row.
+func linePosition(names []string, lines []int) string {
+	var pos []string
+	for i, l := range lines {
+		if l == 0 {
+			continue
+		}
+		name := strconv.Itoa(i)
+		if i < len(names) {
+			name = names[i]
+		}
+		pos = append(pos, fmt.Sprintf("line %d of file %s", l, name))
+	}
+	if len(pos) == 0 {
+		return "unknown line"
+	}
+	return strings.Join(pos, ", ")
+}
+
+// position describes output column col of a row taken from the given input
+// lines for error reporting, e.g. "line 3 of file a column 1"
+func (m missingSpec) position(lines []int, col int) string {
+	origin := fmt.Sprintf("column %d", col)
+	if col < len(m.origins) {
+		origin = m.origins[col]
+	}
+	if col < len(m.files) && m.files[col] < len(lines) && lines[m.files[col]] > 0 {
+		return fmt.Sprintf("line %d of %s", lines[m.files[col]], origin)
+	}
+	return m.rowPosition(lines) + " in " + origin
+}
+
+// splitIntoFloats converts a list of strings into a list of floats. Missing
+// and non-numeric values are handled according to the missingSpec, in skip
+// mode they are converted into NaN. The input lines of the row are used for
+// error reporting.
+func splitIntoFloats(items []string, m missingSpec, lines []int) ([]float64, error) {
+
+	floatList := make([]float64, len(items))
+	for i, item := range items {
+		val, err := parseValue(item, m, lines, i)
+		if err != nil {
+			return nil, err
+		}
+		floatList[i] = val
+	}
+	return floatList, nil
+}
+
+// parseValue converts the item in output column col into a float. Missing and
+// non-numeric values are handled according to the missingSpec, in skip mode
+// they are converted into NaN. The input lines of the row and the column are
+// used for error reporting.
+func parseValue(item string, m missingSpec, lines []int, col int) (float64, error) {
+
+	val, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+	if err != nil {
+		switch m.policy {
+		case failMissing:
+			return val, fmt.Errorf("non-numeric value %q in %s", item,
+				m.position(lines, col))
+		case replaceMissing:
+			val = m.value
+		default:
+			val = math.NaN()
+		}
+	}
+	return val, nil
+}
+
+// dropNaN returns the values of the provided list which are not NaN
+func dropNaN(items []float64) []float64 {
+	values := items[:0]
+	for _, v := range items {
+		if !math.IsNaN(v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// totalLen computes the totla number of items contained in a list of parseSpecs
+func totalLen(spec []ParseSpec) int {
+	var totLen int
+	for _, s := range spec {
+		totLen += len(s)
+	}
+	return totLen
+}
+
+// getInputSepFunc returns a closure used for separating the columns in the
+// input files. Whitespace separated columns are signaled by nil since they are
+// split faster via strings.Fields.
+func getInputSepFunc(inputSep string) func(rune) bool {
+	var inputSepFunc func(rune) bool
+	if len(inputSep) >= 1 {
+		inputSepFunc = func(r rune) bool {
+			for _, s := range inputSep {
+				if s == r {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return inputSepFunc
+}
+
+// makeIntRange creates a slice of ints starting at begin until and including
+// end in increments of step.
+// NOTE: This function assumes end >= begin and step > 0
+func makeIntRange(begin, end, step int) []int {
+	r := make([]int, 0, (end-begin)/step+1)
+	for i := begin; i <= end; i += step {
+		r = append(r, i)
+	}
+	return r
+}
+
+// minMax returns the minimum and maximum value in a ParseSpec
+func (p ParseSpec) minMax() (int, int) {
+	maxVal := -math.MaxInt64
+	minVal := math.MaxInt64
+	for _, v := range p {
+		if v > maxVal {
+			maxVal = v
+		} else if v < minVal {
+			minVal = v
+		}
+	}
+	return minVal, maxVal
+}
+
+// rowRange is used to specify row and column ranges to be processed.
+// Negative values of b and e count from the end, open ranges extend up to
+// the last entry, and only every step-th entry starting at b is selected.
+type rowRange struct {
+	b, e int
+	step int
+	open bool
+}
+
+// resolve returns the beginning and end of the range given the total number
+// of entries n. If n is negative, i.e. not known, and the range depends on
+// it ok is false.
+func (r rowRange) resolve(n int) (b, e int, ok bool) {
+	b, e = r.b, r.e
+	if n < 0 && (b < 0 || e < 0 || r.open) {
+		return b, e, false
+	}
+	if b < 0 {
+		b += n
+	}
+	if r.open {
+		e = n - 1
+	} else if e < 0 {
+		e += n
+	}
+	return b, e, true
+}
+
+// contains tests if v is within the range given the total number of entries
+// n. If n is negative, i.e. not known yet, v is assumed to lie before all
+// entries referred to by negative indices.
+func (r rowRange) contains(v, n int) bool {
+	b, e, ok := r.resolve(n)
+	if !ok {
+		if b < 0 {
+			return false
+		}
+		if r.open || e < 0 {
+			e = math.MaxInt64
+		}
+	}
+	return v >= b && v <= e && (v-b)%r.step == 0
+}
+
+// contains tests if the provided integer value is contained within the supplied
+// row range slice.
+// NOTE: An empty rowRangeSlice as a special case returns always true to
+// enable the default case in which no row processing is specified
+func (rr rowRangeSlice) contains(v int) bool {
+	return rr.containsRow(v, -1)
+}
+
+// containsRow tests if row v of a file with n rows is contained within the
+// row range slice. n may be negative if the number of rows is not known yet,
+// see rowRange.contains.
+func (rr rowRangeSlice) containsRow(v, n int) bool {
+	if len(rr) == 0 {
+		return true
+	}
+
+	for _, r := range rr {
+		if r.contains(v, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxEntry contains the largest integer value in the rowRangeSlice
+// NOTE: If the rowRangeSlice is empty or contains open ranges or ranges
+// ending at a negative index we return MaxInt
+func (rr rowRangeSlice) maxEntry() int {
+	if len(rr) == 0 {
+		return math.MaxInt64
+	}
+
+	var max int
+	for _, r := range rr {
+		if r.open || r.e < 0 {
+			return math.MaxInt64
+		}
+		if max < r.e {
+			max = r.e
+		}
+	}
+	return max
+}
+
+// lookahead returns the number of rows which need to be read past a row
+// before it can be decided if the row is contained in the rowRangeSlice,
+// i.e., the magnitude of the largest negative index
+func (rr rowRangeSlice) lookahead() int {
+	var l int
+	for _, r := range rr {
+		if -r.b > l {
+			l = -r.b
+		}
+		if !r.open && -r.e > l {
+			l = -r.e
+		}
+	}
+	return l
+}
+
+// rowRangeSlice is a helper type to enable sorting
+type rowRangeSlice []rowRange
+
+// sort functionality for rowRangeSlice
+func (rr rowRangeSlice) Len() int {
+	return len(rr)
+}
+
+func (rr rowRangeSlice) Swap(i, j int) {
+	rr[i], rr[j] = rr[j], rr[i]
+}
+
+func (rr rowRangeSlice) Less(i, j int) bool {
+	return rr[i].b < rr[j].b
+}
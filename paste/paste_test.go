@@ -0,0 +1,1854 @@
+// unit tests for pst
+package paste
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_rowRangeSlices tests the rowRangeSlice data structure
+func Test_rowRangeSlice(t *testing.T) {
+	var rr rowRangeSlice
+	rr = append(rr, rowRange{11, 20, 1, false})
+	rr = append(rr, rowRange{4, 9, 1, false})
+	rr = append(rr, rowRange{2, 5, 1, false})
+
+	sort.Sort(rr)
+	if rr[0].b != 2 || rr[1].b != 4 || rr[2].b != 11 {
+		t.Error("error sorting rowRangeSlice")
+	}
+
+	if rr.contains(1) || rr.contains(10) || rr.contains(21) {
+		t.Error("false positive during rowRangeSLice.contains lookup")
+	}
+
+	if !rr.contains(2) || !rr.contains(9) || !rr.contains(3) || !rr.contains(11) ||
+		!rr.contains(17) {
+		t.Error("false negative during rowRangeSLice.contains lookup")
+	}
+
+	if rr.maxEntry() != 20 {
+		t.Error("error during rowRangeSLice.maxEntry")
+		return
+	}
+}
+
+// Test_parseInputSpec checks that parseInputSpec() properly parses the provided
+// input spec string
+func Test_parseInputSpec(t *testing.T) {
+
+	inputString := "0,1-3,10|14,7,2|1,1-4"
+	expectedResult := []ParseSpec{ParseSpec{0, 1, 2, 3, 10}, ParseSpec{14, 7, 2},
+		ParseSpec{1, 1, 2, 3, 4}}
+	result, err := parseInputSpec(inputString, nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != len(expectedResult) {
+		t.Errorf("length mismatch between expected and computed result")
+		return
+	}
+
+	for i, r := range result {
+		if !parseSpecsIdentical(r, expectedResult[i]) {
+			t.Errorf("expected %v and computed %v results don't match", r, expectedResult[i])
+			return
+		}
+	}
+}
+
+// Test_parseInputSpec checks that parseInputSpec() properly parses the provided
+// input spec string
+func Test_parseOutputSpec(t *testing.T) {
+
+	inputString := "0,1-3,10,14,7,2,1,4"
+	expectedResult := ParseSpec{0, 1, 2, 3, 10, 14, 7, 2, 1, 4}
+	result, err := parseOutputSpec(inputString, nil, -1, nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != len(expectedResult) {
+		t.Errorf("length mismatch between expected and computed result")
+		return
+	}
+
+	if !parseSpecsIdentical(result, expectedResult) {
+		t.Errorf("expected %v and computed %v results don't match", result, expectedResult)
+		return
+	}
+}
+
+// Test_parseNamedSpecs checks that column names in the input and output specs
+// are resolved against the provided headers
+func Test_parseNamedSpecs(t *testing.T) {
+
+	headers := [][]string{[]string{"time", "temp", "pressure"},
+		[]string{"pressure", "time"}, []string{"humidity", "time", "pressure"}}
+	expectedResult := []ParseSpec{ParseSpec{0, 1}, ParseSpec{0}, ParseSpec{2}}
+	result, err := getInputSpec("time,temp|pressure", 3, headers, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != len(expectedResult) {
+		t.Errorf("length mismatch between expected and computed result")
+		return
+	}
+
+	for i, r := range result {
+		if !parseSpecsIdentical(r, expectedResult[i]) {
+			t.Errorf("expected %v and computed %v results don't match", expectedResult[i], r)
+			return
+		}
+	}
+
+	if _, err := getInputSpec("time,temp|humidity", 3, headers, nil); err == nil ||
+		!strings.Contains(err.Error(), "pressure, time") {
+		t.Errorf("expected error listing available columns but got %v", err)
+	}
+
+	names := []string{"time", "temp", "pressure"}
+	outResult, err := getOutputSpec("temp,2,time", len(names), names, nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !parseSpecsIdentical(outResult, ParseSpec{1, 2, 0}) {
+		t.Errorf("expected %v and computed %v results don't match", ParseSpec{1, 2, 0},
+			outResult)
+	}
+}
+
+// Test_getOutputHeader checks that the output header follows the output spec
+// and disambiguates names occurring in several files
+func Test_getOutputHeader(t *testing.T) {
+
+	inputs := []*inputFile{
+		&inputFile{name: "a.txt", header: []string{"time", "temp"}},
+		&inputFile{name: "b.txt", header: []string{"pressure", "time"}}}
+	names, err := getColumnNames(inputs, []ParseSpec{ParseSpec{0, 1}, ParseSpec{1, 0}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	header := getOutputHeader(names, ParseSpec{3, 1, 2, 0}, "", false)
+	expectedResult := []string{"pressure", "temp", "b.txt:time", "a.txt:time"}
+	if !stringsIdentical(header, expectedResult) {
+		t.Errorf("expected %v and computed %v headers don't match", expectedResult, header)
+	}
+
+	header = getOutputHeader(names, ParseSpec{3, 1}, "mean, std", false)
+	if !stringsIdentical(header, []string{"mean", "std"}) {
+		t.Errorf("expected compute action names but got %v", header)
+	}
+
+	header = getOutputHeader(names, ParseSpec{3, 1}, "mean, std", true)
+	if !stringsIdentical(header, []string{"pressure", "temp", "mean", "std"}) {
+		t.Errorf("expected column names followed by compute action names but got %v",
+			header)
+	}
+}
+
+// Test_parseRowSpec checks that parseRowSpec() properly parses the provided
+// row spec string
+func Test_parseRowSpec(t *testing.T) {
+
+	inputString := "0,1-3,10,14,7,2,1-4"
+	expectedResult := []rowRange{rowRange{0, 0, 1, false}, rowRange{1, 3, 1, false}, rowRange{10, 10, 1, false},
+		rowRange{14, 14, 1, false}, rowRange{7, 7, 1, false}, rowRange{2, 2, 1, false}, rowRange{1, 4, 1, false}}
+	result, err := parseRowSpec(inputString)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != len(expectedResult) {
+		t.Errorf("length mismatch between expected and computed result")
+		return
+	}
+
+	var er rowRange
+	for i, rr := range result {
+		er = expectedResult[i]
+		if rr.b != er.b || rr.e != er.e {
+			t.Errorf("expected %v and computed %v results don't match", rr, er)
+			return
+		}
+	}
+}
+
+// Test_parseRange checks that open ended, negative, and strided ranges are
+// parsed properly
+func Test_parseRange(t *testing.T) {
+
+	inputs := []string{"5", "2-7", "100-", "-5", "-3-", "-3--1", "0-1000:10", "4-:2"}
+	expectedResult := []rowRange{rowRange{5, 5, 1, false}, rowRange{2, 7, 1, false},
+		rowRange{100, 0, 1, true}, rowRange{0, 5, 1, false}, rowRange{-3, 0, 1, true},
+		rowRange{-3, -1, 1, false}, rowRange{0, 1000, 10, false}, rowRange{4, 0, 2, true}}
+	for i, input := range inputs {
+		r, err := parseRange(input)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if r != expectedResult[i] {
+			t.Errorf("expected %v and computed %v ranges for %s don't match",
+				expectedResult[i], r, input)
+		}
+	}
+
+	for _, input := range []string{"-", "7-3", "a-b", "1-2-3", "0-10:0", "0-10:x"} {
+		if _, err := parseRange(input); err == nil {
+			t.Errorf("expected an error for invalid range %s", input)
+		}
+	}
+}
+
+// Test_resolveColumnRanges checks that open ended and negative column ranges
+// are resolved against the number of columns of each file
+func Test_resolveColumnRanges(t *testing.T) {
+
+	result, err := parseInputSpec("-2-|0-:2,-1--1", nil, []int{4, 5, 3})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectedResult := []ParseSpec{ParseSpec{2, 3}, ParseSpec{0, 2, 4, 4},
+		ParseSpec{0, 2, 2}}
+	for i, r := range result {
+		if !parseSpecsIdentical(r, expectedResult[i]) {
+			t.Errorf("expected %v and computed %v parseSpecs don't match",
+				expectedResult[i], r)
+		}
+	}
+
+	if _, err := parseInputSpec("2-", nil, nil); err == nil {
+		t.Error("expected an error for an open range with unknown number of columns")
+	}
+	if _, err := parseInputSpec("-5--1", nil, []int{3}); err == nil {
+		t.Error("expected an error for an out of bounds negative column index")
+	}
+
+	outResult, err := getOutputSpec("-1--1,-3-:2", 4, nil, nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !parseSpecsIdentical(outResult, ParseSpec{3, 1, 3}) {
+		t.Errorf("expected %v and computed %v output specs don't match",
+			ParseSpec{3, 1, 3}, outResult)
+	}
+}
+
+// Test_qualifiedOutputSpec checks that output columns qualified by their
+// input file are resolved against the per file input specs
+func Test_qualifiedOutputSpec(t *testing.T) {
+
+	inCols := []ParseSpec{ParseSpec{0, 3}, ParseSpec{1, 2}, ParseSpec{0, 1, 2}}
+	result, err := getOutputSpec("2:1,1:2,0:0,2:0-2,1", 7, nil, inCols, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectedResult := ParseSpec{5, 3, 0, 4, 5, 6, 1}
+	if !parseSpecsIdentical(result, expectedResult) {
+		t.Errorf("expected %v and computed %v output specs don't match",
+			expectedResult, result)
+	}
+
+	for _, output := range []string{"0:1", "3:0", "1:x"} {
+		if _, err := getOutputSpec(output, 7, nil, inCols, nil); err == nil {
+			t.Errorf("expected an error for output spec %s", output)
+		}
+	}
+}
+
+// Test_rowRangeEnds checks open ended, negative, and strided row ranges
+// both before and after the number of rows is known
+func Test_rowRangeEnds(t *testing.T) {
+
+	ranges, err := parseRowSpec("8-,0-5:2,-2--1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rr := rowRangeSlice(ranges)
+	if rr.maxEntry() != math.MaxInt64 || rr.lookahead() != 2 {
+		t.Errorf("expected maxEntry %d and lookahead 2 but got %d and %d",
+			math.MaxInt64, rr.maxEntry(), rr.lookahead())
+	}
+
+	var rows []int
+	for i := 0; i < 10; i++ {
+		if rr.containsRow(i, 7) {
+			rows = append(rows, i)
+		}
+	}
+	expectedRows := []int{0, 2, 4, 5, 6}
+	if !parseSpecsIdentical(rows, expectedRows) {
+		t.Errorf("expected %v and computed %v rows don't match", expectedRows, rows)
+	}
+
+	// while the number of rows is unknown negative ranges lie ahead
+	if rr.contains(6) || !rr.contains(4) || !rr.contains(100) {
+		t.Error("error during rowRangeSlice.contains with unknown number of rows")
+	}
+}
+
+// parseSpecsIdentical is a helper function for checking two parseSpecs for identity
+func parseSpecsIdentical(x, y ParseSpec) bool {
+	if len(x) != len(y) {
+		return false
+	}
+
+	for i, v := range x {
+		if v != y[i] {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// Test_csvRecordReader checks that csv mode handles quoted fields, escaped
+// quotes and records spanning multiple lines
+func Test_csvRecordReader(t *testing.T) {
+
+	input := "name,comment,value\n\"Smith, John\",\"said \"\"hi\"\"\",1\n" +
+		"Doe,\"two\nlines\",2\n"
+	expectedResult := [][]string{
+		[]string{"name", "comment", "value"},
+		[]string{"Smith, John", "said \"hi\"", "1"},
+		[]string{"Doe", "two\nlines", "2"}}
+
+	newReader, err := getRecordReaderFunc("", true, false, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := newReader(strings.NewReader(input))
+	for i, e := range expectedResult {
+		r, err := records.Read()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !stringsIdentical(r, e) {
+			t.Errorf("expected %q and computed %q records don't match in row %d", e, r, i)
+		}
+	}
+	if _, err := records.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF after final record but got %v", err)
+	}
+
+	if _, err := getRecordReaderFunc(";:", true, false, 0); err == nil {
+		t.Error("failed to reject multi character csv separator")
+	}
+}
+
+// stringsIdentical is a helper function for checking two string slices for identity
+func stringsIdentical(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+
+	for i, v := range x {
+		if v != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Test_joinRows checks that the merge and hash based key joins combine rows
+// with matching keys and handle missing and duplicate keys
+func Test_joinRows(t *testing.T) {
+
+	file1 := [][]string{[]string{"a", "1"}, []string{"b", "2"}, []string{"bb", "2"},
+		[]string{"d", "4"}}
+	file2 := [][]string{[]string{"x", "2"}, []string{"y", "3"}, []string{"z", "4.0"}}
+	expectedResult := map[joinType][][]string{
+		innerJoin: [][]string{[]string{"b", "x"}, []string{"bb", "x"}, []string{"d", "z"}},
+		leftJoin: [][]string{[]string{"a", ""}, []string{"b", "x"}, []string{"bb", "x"},
+			[]string{"d", "z"}},
+		outerJoin: [][]string{[]string{"a", ""}, []string{"b", "x"}, []string{"bb", "x"},
+			[]string{"", "y"}, []string{"d", "z"}},
+	}
+
+	// an unsorted file is joined via hashing once its first key out of
+	// order is encountered. Keys are then output in the order of their first
+	// appearance with keys missing in the first file last.
+	unsorted := [][]string{[]string{"b", "2"}, []string{"a", "1"}, []string{"bb", "2"},
+		[]string{"d", "4"}}
+	expectedUnsorted := map[joinType][][]string{
+		innerJoin: expectedResult[innerJoin],
+		leftJoin: [][]string{[]string{"b", "x"}, []string{"bb", "x"}, []string{"a", ""},
+			[]string{"d", "z"}},
+		outerJoin: [][]string{[]string{"b", "x"}, []string{"bb", "x"}, []string{"a", ""},
+			[]string{"d", "z"}, []string{"", "y"}},
+	}
+
+	for _, sorted := range []bool{true, false} {
+		for kind, expected := range expectedResult {
+			streams := newRowStreams(file1, file2)
+			if !sorted {
+				streams = newRowStreams(unsorted, file2)
+				expected = expectedUnsorted[kind]
+			}
+			j := joinSpec{keyPos: []int{-1, -1}, widths: []int{1, 1},
+				names: []string{"file1", "file2"}, kind: kind}
+
+			next := joinRows(streams, make(chan error), j)
+			var result [][]string
+			for {
+				row, _, err := next()
+				if err != nil {
+					t.Error(err)
+					return
+				} else if row == nil {
+					break
+				}
+				result = append(result, row)
+			}
+
+			if len(result) != len(expected) {
+				t.Errorf("expected %v and computed %v join results don't match", expected,
+					result)
+				continue
+			}
+			for i, r := range result {
+				if !stringsIdentical(r, expected[i]) {
+					t.Errorf("expected %v and computed %v join results don't match",
+						expected, result)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Test_compareKeys checks that keys are compared numerically if possible and
+// that NaN keys are compared as strings
+func Test_compareKeys(t *testing.T) {
+
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2", "10", -1}, {"1.0", "1", 0}, {"-Inf", "0", -1}, {"a", "b", -1},
+		{"NaN", "1", 1}, {"1", "NaN", -1}, {"NaN", "NaN", 0}, {"nan", "NaN", 1},
+	}
+	for _, test := range tests {
+		if cmp := compareKeys(test.a, test.b); cmp != test.expected {
+			t.Errorf("expected %d and computed %d comparison of keys %s and %s "+
+				"don't match", test.expected, cmp, test.a, test.b)
+		}
+	}
+	if normalizeKey("nan") != "nan" || normalizeKey("1.0") != "1" {
+		t.Error("NaN keys should not be normalized")
+	}
+
+	// a NaN key is not merged into the group of a numeric key
+	p, err := NewPipeline(Spec{Input: "0,1|1", Keys: "0|0", Join: "outer", Fill: "NA",
+		OutputSep: " "})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	err = p.Run(&buf, Input{"a", strings.NewReader("1 a\n2 b\n")},
+		Input{"b", strings.NewReader("1 x\nNaN y\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "1 a x\n2 b NA\nNaN NA y\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected, buf.String())
+	}
+}
+
+// Test_outerJoinKeys checks that rows of an outer join with keys missing in
+// some files show the key in the key columns of those files
+func Test_outerJoinKeys(t *testing.T) {
+
+	expected := "1 a NA\n2 b x\n3.0 NA y\n"
+	p, err := NewPipeline(Spec{Input: "0,1|0,1", Keys: "0|0", Join: "outer",
+		Fill: "NA", OutputSep: " "})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	err = p.Run(&buf, Input{"a", strings.NewReader("1 a\n2 b\n")},
+		Input{"b", strings.NewReader("2 x\n3 y\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "1 a 1 NA\n2 b 2 x\n3 NA 3 y\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected,
+			buf.String())
+	}
+
+	// the key is only printed once if the other files omit their key column
+	p, err = NewPipeline(Spec{Input: "0,1|1", Keys: "0|0", Join: "outer",
+		Fill: "NA", OutputSep: " "})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	err = p.Run(&buf, Input{"a", strings.NewReader("1 a\n2 b\n")},
+		Input{"b", strings.NewReader("2 x\n3.0 y\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected,
+			buf.String())
+	}
+
+	// unsorted files are joined via hashing without an error
+	p, err = NewPipeline(Spec{Input: "0,1|0,1", Keys: "0|0", Join: "outer",
+		Fill: "NA", OutputSep: " "})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	err = p.Run(&buf, Input{"a", strings.NewReader("2 b\n1 a\n")},
+		Input{"b", strings.NewReader("2 x\n3 y\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "2 b 2 x\n1 a 1 NA\n3 NA 3 y\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected,
+			buf.String())
+	}
+}
+
+// newRowStreams returns a rowStream for each of the provided files which
+// delivers its rows in batches of two. The rows are taken from lines 1, 2, ...
+func newRowStreams(files ...[][]string) []*rowStream {
+	streams := make([]*rowStream, len(files))
+	for i, f := range files {
+		ch := make(chan *rowBatch, len(f))
+		for b := 0; b < len(f); b += 2 {
+			e := b + 2
+			if e > len(f) {
+				e = len(f)
+			}
+			lines := make([]int, e-b)
+			for l := range lines {
+				lines[l] = b + l + 1
+			}
+			ch <- &rowBatch{rows: f[b:e], lines: lines}
+		}
+		close(ch)
+		streams[i] = &rowStream{ch: ch}
+	}
+	return streams
+}
+
+// Test_pasteRows checks the policies for pasting files with different numbers
+// of rows
+func Test_pasteRows(t *testing.T) {
+
+	newDataChs := func() []*rowStream {
+		return newRowStreams([][]string{[]string{"1"}, []string{"2"}},
+			[][]string{[]string{"3"}})
+	}
+	p := pasteSpec{fill: "NA", widths: []int{1, 1}, names: []string{"a", "b"}}
+
+	p.policy = padRows
+	next := pasteRows(newDataChs(), make(chan error), p)
+	for _, expected := range [][]string{[]string{"1", "3"}, []string{"2", "NA"}, nil} {
+		row, _, err := next()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !stringsIdentical(row, expected) {
+			t.Errorf("expected %v and computed %v rows don't match", expected, row)
+		}
+	}
+
+	p.policy = shortestRows
+	next = pasteRows(newDataChs(), make(chan error), p)
+	if row, _, err := next(); err != nil || !stringsIdentical(row, []string{"1", "3"}) {
+		t.Errorf("expected [1 3] but got %v (%v)", row, err)
+	}
+	if row, _, err := next(); err != nil || row != nil {
+		t.Errorf("expected end of data but got %v (%v)", row, err)
+	}
+
+	p.policy = strictRows
+	next = pasteRows(newDataChs(), make(chan error), p)
+	next()
+	if _, _, err := next(); err == nil || !strings.Contains(err.Error(), "file b") {
+		t.Errorf("expected error naming file b but got %v", err)
+	}
+
+	// the error refers to the lines of the files rather than the output rows
+	pipeline, err := NewPipeline(Spec{Input: "0", Rows: "1-", Uneven: "strict"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = pipeline.Run(io.Discard, Input{"a", strings.NewReader("1\n2\n3\n4\n5\n")},
+		Input{"b", strings.NewReader("1\n2\n3\n")})
+	expected := "file b ended after line 3 while other files continue with line 4 of file a"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected error %q but got %v", expected, err)
+	}
+}
+
+// Test_decompress checks that compressed input streams are detected and
+// decompressed and that uncompressed streams are passed through
+func Test_decompress(t *testing.T) {
+
+	content := "1 2\n3 4\n"
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(content))
+	w.Close()
+
+	// bzip2 compressed content
+	bz := []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xd0, 0xb5,
+		0x1d, 0x3d, 0x00, 0x00, 0x02, 0x58, 0x00, 0x00, 0x10, 0x40, 0x00, 0x3c,
+		0x00, 0x20, 0x00, 0x30, 0xc0, 0x08, 0x69, 0xb2, 0x88, 0x23, 0x27, 0x8b,
+		0xb9, 0x22, 0x9c, 0x28, 0x48, 0x68, 0x5a, 0x8e, 0x9e, 0x80}
+
+	for _, input := range [][]byte{[]byte(content), gz.Bytes(), bz} {
+		r, err := decompress(bytes.NewReader(input))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		result, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(result) != content {
+			t.Errorf("expected %q and decompressed %q content don't match", content, result)
+		}
+	}
+}
+
+// Test_decompressCommands checks that xz and zstd compressed input streams
+// are decompressed via their command line tools if these are installed
+func Test_decompressCommands(t *testing.T) {
+
+	content := "1 2\n3 4\n"
+	for _, tool := range []string{"xz", "zstd"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Logf("skipping %s input since the %s command is not available", tool, tool)
+			continue
+		}
+		cmd := exec.Command(tool, "-c")
+		cmd.Stdin = strings.NewReader(content)
+		compressed, err := cmd.Output()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		r, err := decompress(bytes.NewReader(compressed))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		result, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(result) != content {
+			t.Errorf("expected %q and decompressed %s content %q don't match", content,
+				tool, result)
+		}
+
+		// corrupt streams fail instead of yielding truncated content
+		corrupt := append([]byte{}, compressed...)
+		for i := len(corrupt) / 2; i < len(corrupt); i++ {
+			corrupt[i] ^= 0xff
+		}
+		if r, err = decompress(bytes.NewReader(corrupt)); err == nil {
+			_, err = io.ReadAll(r)
+			r.Close()
+		}
+		if err == nil {
+			t.Errorf("expected an error for corrupt %s input", tool)
+		}
+	}
+}
+
+// Test_decompressClose checks that closing an xz decompression stops reading
+// from an input pipe which is not written to anymore
+func Test_decompressClose(t *testing.T) {
+
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("the xz command is not available")
+	}
+	cmd := exec.Command("xz", "-c")
+	cmd.Stdin = strings.NewReader(strings.Repeat("1 2\n3 4\n", 10000))
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(compressed[:len(compressed)/2])
+	r, err := decompress(pr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("closing the decompressor blocked on its input")
+	}
+}
+
+// Test_decompressMissingCommand checks that a missing decompression command
+// is reported together with the file name
+func Test_decompressMissingCommand(t *testing.T) {
+
+	t.Setenv("PATH", "")
+	p, err := NewPipeline(Spec{Input: "0"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = p.Run(io.Discard, Input{"data.xz", bytes.NewReader(xzMagic)})
+	if err == nil || !strings.Contains(err.Error(), "data.xz") ||
+		!strings.Contains(err.Error(), "xz command") {
+		t.Errorf("expected an error naming file data.xz and the xz command but got %v", err)
+	}
+}
+
+// Test_decompressCorrupt checks that corrupt or truncated gzip and bzip2
+// input streams result in an error
+func Test_decompressCorrupt(t *testing.T) {
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(strings.Repeat("1 2\n3 4\n", 100)))
+	w.Close()
+	truncated := gz.Bytes()[:gz.Len()/2]
+	corrupt := append([]byte{}, gz.Bytes()...)
+	for i := 10; i < len(corrupt); i++ {
+		corrupt[i] ^= 0x55
+	}
+	bz := []byte("BZh91AY&SYgarbage")
+
+	for _, input := range [][]byte{truncated, corrupt, bz, gzipMagic} {
+		r, err := decompress(bytes.NewReader(input))
+		if err == nil {
+			_, err = io.ReadAll(r)
+			r.Close()
+		}
+		if err == nil {
+			t.Errorf("expected an error for corrupt input %q", input)
+		}
+	}
+
+	// the error names the offending file
+	p, err := NewPipeline(Spec{Input: "0"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = p.Run(io.Discard, Input{"plain", strings.NewReader("1\n")},
+		Input{"broken", bytes.NewReader(truncated)})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected an error naming file broken but got %v", err)
+	}
+}
+
+// Test_openInputs checks that inputs are opened concurrently so that an
+// input which only becomes readable after another one does not block
+func Test_openInputs(t *testing.T) {
+
+	ra, wa := io.Pipe()
+	rb, wb := io.Pipe()
+	go func() {
+		wb.Write([]byte("3 4\n"))
+		wb.Close()
+		wa.Write([]byte("1 2\n"))
+		wa.Close()
+	}()
+
+	type result struct {
+		inputs []*inputFile
+		err    error
+	}
+	newReader, err := getRecordReaderFunc("", false, false, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	opened := make(chan result, 1)
+	go func() {
+		inputs, err := openInputs([]Input{{"a", ra}, {"b", rb}}, newReader, nil, true)
+		opened <- result{inputs, err}
+	}()
+
+	select {
+	case r := <-opened:
+		if r.err != nil {
+			t.Error(r.err)
+			return
+		}
+		defer closeInputs(r.inputs)
+		for i, expected := range [][]string{{"1", "2"}, {"3", "4"}} {
+			if !stringsIdentical(r.inputs[i].header, expected) {
+				t.Errorf("expected %v and computed %v header don't match", expected,
+					r.inputs[i].header)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("opening inputs blocked on the first input")
+		ra.Close()
+		rb.Close()
+	}
+}
+
+// Test_printRow checks that computed values replace or follow the row
+func Test_printRow(t *testing.T) {
+
+	actions, err := getComputeSpecs("mean,max")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	formats, err := getNumberFormats("", []string{"mean", "max"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	output := newRowWriter(plainOutput, &buf, " ")
+	out := outputSpec{sep: " ", actions: actions, formats: formats}
+	if err := printRow(output, []string{"1", "3"}, nil, out); err != nil {
+		t.Error(err)
+		return
+	}
+	out.appendCols = true
+	if err := printRow(output, []string{"1", "3"}, nil, out); err != nil {
+		t.Error(err)
+		return
+	}
+	output.flush()
+	expectedResult := "2.000000000000000 3.000000000000000\n" +
+		"1 3 2.000000000000000 3.000000000000000\n"
+	if buf.String() != expectedResult {
+		t.Errorf("expected %q and computed %q output don't match", expectedResult,
+			buf.String())
+	}
+}
+
+// Test_columnSummary checks column wise statistics across all rows
+func Test_columnSummary(t *testing.T) {
+
+	s, err := getColumnSummary("mean, median, min, max, var")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if s.formats, err = getNumberFormats("", actionNames("mean, median, min, max, var")); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, row := range [][]string{[]string{"1", "10"}, []string{"2", "20"},
+		[]string{"6", "30"}} {
+		if err := s.add(row, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	output := newRowWriter(plainOutput, &buf, ",")
+	if err := s.print(output); err != nil {
+		t.Error(err)
+		return
+	}
+	output.flush()
+	expectedResult := "mean,3.000000000000000,20.000000000000000\n" +
+		"median,2.000000000000000,20.000000000000000\n" +
+		"min,1.000000000000000,10.000000000000000\n" +
+		"max,6.000000000000000,30.000000000000000\n" +
+		"var,7.000000000000000,100.000000000000000\n"
+	if buf.String() != expectedResult {
+		t.Errorf("expected %q and computed %q summaries don't match", expectedResult,
+			buf.String())
+	}
+
+	if err := s.add([]string{"1"}, nil); err == nil {
+		t.Error("failed to reject row with missing column")
+	}
+
+	// infinite values yield the same mean as the row wise mean
+	s, _ = getColumnSummary("mean")
+	for _, v := range []string{"1", "+Inf", "2"} {
+		if err := s.add([]string{v}, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if r := s.actions[0](s.columns[0]); !math.IsInf(r, 1) {
+		t.Errorf("expected +Inf but computed %v as mean of column with +Inf", r)
+	}
+}
+
+// Test_getNumberFormats checks the parsing of default and per action number
+// formats
+func Test_getNumberFormats(t *testing.T) {
+
+	formats, err := getNumberFormats("%.3e, std=shortest, max=%.2f", []string{"mean", "std", "max"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectedResult := []string{"1.235e-12", "1.23456789e-12", "0.00"}
+	for i, f := range formats {
+		if r := f(1.23456789e-12); r != expectedResult[i] {
+			t.Errorf("expected %s and computed %s formats don't match", expectedResult[i], r)
+		}
+	}
+
+	for _, bad := range []string{"%d", "%s", "%.3f %.3f", "min=%g"} {
+		if _, err := getNumberFormats(bad, []string{"mean"}); err == nil {
+			t.Errorf("failed to reject invalid number format %s", bad)
+		}
+	}
+
+	// number formats have to agree with fmt
+	values := []float64{0, 1, -1.5, 1234.5678, 1e-300, 1e300, 123456789012345678,
+		math.Inf(1), math.Inf(-1), math.NaN()}
+	for _, format := range []string{"%f", "%e", "%g", "%E", "%G", "%F", "%.3f", "%15.15f",
+		"%8.2e", "%.0g", "%20g", "%.10G", "%3f", "%+.2f", "%-12g", "%012.3f"} {
+		f, err := parseNumberFormat(format)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, v := range values {
+			expected := fmt.Sprintf(format, v)
+			if r := f(v); r != expected {
+				t.Errorf("expected %q and computed %q for format %s don't match", expected, r,
+					format)
+			}
+		}
+	}
+}
+
+// Test_quantileActions checks the quantile compute actions against reference
+// values computed with Python's statistics.quantiles(method="inclusive"),
+// which matches the linear interpolation of R and NumPy
+func Test_quantileActions(t *testing.T) {
+
+	data := []float64{3.2, -1.5, 7.7, 0.0, 12.25, 4.4, 4.4, 9.1}
+	expectedResult := []float64{-0.45, 11.1475, 12.22795, 2.4, 4.4, 8.05, 5.65, -1.5,
+		12.25, -1.5, 2.4, 4.4, 8.05, 12.25}
+	actions, err := getComputeSpecs("p10, p95, p99.9, q(0.25), q(0.5), q(0.75), iqr, p0, " +
+		"q(1), fivenum")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(actions) != len(expectedResult) {
+		t.Errorf("expected %d compute actions but got %d", len(expectedResult), len(actions))
+		return
+	}
+	for i, a := range actions {
+		if r := a(data); math.Abs(r-expectedResult[i]) > 1e-12 {
+			t.Errorf("expected %v and computed %v quantiles don't match for action #%d",
+				expectedResult[i], r, i)
+		}
+	}
+
+	for _, bad := range []string{"p101", "q(1.5)", "q(-0.1)", "px", "q(0.5"} {
+		if _, err := getComputeSpecs(bad); err == nil {
+			t.Errorf("failed to reject invalid quantile action %s", bad)
+		}
+	}
+}
+
+// Test_missingPolicy checks the handling of missing and non-numeric values
+// by the compute actions
+func Test_missingPolicy(t *testing.T) {
+
+	actions, err := getComputeSpecs("mean, max")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	formats, err := getNumberFormats("%g", []string{"mean", "max"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	inputs := []*inputFile{&inputFile{name: "a.txt"}}
+	row := []string{"1", "NA", "", "-", "5", "NaN"}
+
+	expectedResult := map[string]string{
+		"skip": "3 5\n",
+		"nan":  "NaN NaN\n",
+		"0":    "1.2 5\n",
+	}
+	for policy, expected := range expectedResult {
+		m, err := getMissingSpec(policy, inputs, []ParseSpec{ParseSpec{0, 1, 2, 3, 4, 5}}, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		out := outputSpec{sep: " ", actions: actions, formats: formats, missing: m}
+		if policy == "0" {
+			// NaN is a valid number and thus not replaced
+			row = row[:5]
+		}
+
+		var buf bytes.Buffer
+		output := newRowWriter(plainOutput, &buf, " ")
+		if err := printRow(output, row, nil, out); err != nil {
+			t.Error(err)
+			return
+		}
+		output.flush()
+		if buf.String() != expected {
+			t.Errorf("expected %q and computed %q output with %s policy don't match",
+				expected, buf.String(), policy)
+		}
+	}
+
+	m, err := getMissingSpec("fail", inputs, []ParseSpec{ParseSpec{3, 7}}, ParseSpec{1, 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = splitIntoFloats([]string{"1", "NA"}, m, []int{12})
+	if err == nil || !strings.Contains(err.Error(), "line 12 of file a.txt column 3") {
+		t.Errorf("expected error naming file, row and column but got %v", err)
+	}
+}
+
+// Test_parseExprList checks parsing and evaluation of output expressions
+func Test_parseExprList(t *testing.T) {
+
+	row := []string{"1", "2", "ERR", "8", "-3"}
+	input := `c0, c3/c1, log(c3)/log(2), c4-c1*2^2, -c4 % 2, c2 == "ERR" ? 0 : c2, ` +
+		`if(c0 > 1 || c1 <= 2, "yes", "no"), max(c0, c3, 3), !(c0 != 1) && c2, (c0+c1)*c1`
+	expectedResult := []string{"1", "4", "3", "-11", "1", "0", "yes", "8", "1", "6"}
+	exprs, sources, err := parseExprList(input, len(row)-1, missingSpec{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(exprs) != len(expectedResult) || sources[1] != "c3/c1" {
+		t.Errorf("expected %d expressions but got %d: %q", len(expectedResult),
+			len(exprs), sources)
+		return
+	}
+	for i, e := range exprs {
+		v, err := e(row)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if v.String() != expectedResult[i] {
+			t.Errorf("expected %s and computed %s results of %s don't match",
+				expectedResult[i], v, sources[i])
+		}
+	}
+
+	for _, bad := range []string{"c5", "c0 +", "foo(c0)", "log(c0, c1)", "(c0", "x1",
+		"\"abc", "c0 # c1"} {
+		if _, _, err := parseExprList(bad, len(row)-1, missingSpec{}); err == nil {
+			t.Errorf("failed to reject invalid expression %s", bad)
+		}
+	}
+
+	exprs, _, _ = parseExprList("c2 * 2", -1, missingSpec{})
+	if _, err := exprs[0](row); err == nil {
+		t.Error("failed to reject arithmetic on non-numeric value")
+	}
+
+	// comparisons with numbers are numeric and apply the missing value policy
+	// to non-numeric values
+	cond := `c2 > 3 ? "big" : "small", c2 < 3, c2 != 3, c3 > c2`
+	for _, test := range []struct {
+		m        missingSpec
+		expected []string
+	}{
+		{missingSpec{policy: skipMissing}, []string{"small", "0", "0", "0"}},
+		{missingSpec{policy: nanMissing}, []string{"small", "0", "0", "0"}},
+		{missingSpec{policy: replaceMissing, value: 5}, []string{"big", "0", "1", "0"}},
+	} {
+		exprs, sources, err := parseExprList(cond, len(row)-1, test.m)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for i, e := range exprs {
+			v, err := e(row)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if v.String() != test.expected[i] {
+				t.Errorf("expected %s and computed %s results of %s don't match",
+					test.expected[i], v, sources[i])
+			}
+		}
+	}
+	exprs, _, _ = parseExprList("c2 > 3", len(row)-1, missingSpec{})
+	if _, err := exprs[0](row); err == nil {
+		t.Error("failed to reject comparison of non-numeric value with a number")
+	}
+}
+
+// Test_filter checks that row filters select the expected rows
+func Test_filter(t *testing.T) {
+
+	filter, err := parseExpr(`c1 > 0.5 && c0 != "ERR"`, 1, missingSpec{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rows := [][]string{[]string{"ok", "0.7"}, []string{"ok", "0.2"},
+		[]string{"ERR", "0.9"}, []string{"ok", "1e3"}}
+	expectedResult := []bool{true, false, false, true}
+	for i, r := range rows {
+		keep, err := filter(r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if keep.truth() != expectedResult[i] {
+			t.Errorf("expected %v but filter returned %v for row %v", expectedResult[i],
+				keep.truth(), r)
+		}
+	}
+
+	// missing values never pass numeric filters unless they are replaced
+	for _, test := range []struct {
+		m        missingSpec
+		expected []bool
+	}{
+		{missingSpec{policy: skipMissing}, []bool{false, false, true}},
+		{missingSpec{policy: nanMissing}, []bool{false, false, true}},
+		{missingSpec{policy: replaceMissing, value: 4}, []bool{true, true, true}},
+	} {
+		filter, err := parseExpr("c1 > 3", 1, test.m)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for i, r := range [][]string{{"ok", "NA"}, {"ok", ""}, {"ok", "7"}} {
+			keep, err := filter(r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if keep.truth() != test.expected[i] {
+				t.Errorf("expected %v but filter returned %v for row %q", test.expected[i],
+					keep.truth(), r)
+			}
+		}
+	}
+	filter, _ = parseExpr("c1 > 3", 1, missingSpec{})
+	if _, err := filter([]string{"ok", "NA"}); err == nil {
+		t.Error("failed to reject missing value in numeric filter")
+	}
+
+	if _, err := parseExpr("c0 > 1, c1 > 2", 1, missingSpec{}); err == nil {
+		t.Error("failed to reject filter consisting of several expressions")
+	}
+}
+
+// Test_rollingStats compares the windowed and running statistics to the
+// corresponding statistics computed from scratch for each window
+func Test_rollingStats(t *testing.T) {
+
+	data := []float64{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 1e6, 2}
+	window := 4
+	r, err := getRollingSpec("mean(c0, 4), std(c0,4), var(c0,4), min(c0,4), "+
+		"max(c0,4), median(c0,4), cumsum(c0), runmean(c0)", 0, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	std := func(items []float64) float64 { return math.Sqrt(variance(items)) }
+	windowActions := []ComputeAction{mean, std, variance, min, max, median}
+	for i, v := range data {
+		row, err := r.add([]string{strconv.FormatFloat(v, 'g', -1, 64)}, nil, missingSpec{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		results, err := splitIntoFloats(row[1:], missingSpec{}, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expectedResult := make([]float64, len(windowActions))
+		for j, a := range windowActions {
+			expectedResult[j] = math.NaN()
+			if i+1 >= window {
+				expectedResult[j] = a(data[i+1-window : i+1])
+			}
+		}
+		expectedResult = append(expectedResult, sum(data[:i+1]), mean(data[:i+1]))
+
+		for j, e := range expectedResult {
+			if !(math.IsNaN(e) && math.IsNaN(results[j])) &&
+				math.Abs(results[j]-e) > 1e-9*math.Max(1, math.Abs(e)) {
+				t.Errorf("expected %v and computed %v results of %s in row %d don't match",
+					e, results[j], r.names[j], i)
+			}
+		}
+	}
+}
+
+// Test_rollingMissing checks the handling of missing values by windowed
+// statistics in skip and nan mode
+func Test_rollingMissing(t *testing.T) {
+
+	rows := []string{"1", "NA", "3", "5", "7"}
+	expectedResults := map[missingPolicy][]string{
+		skipMissing: []string{"NaN", "1", "3", "4", "6"},
+		nanMissing:  []string{"NaN", "NaN", "NaN", "4", "6"},
+	}
+	for policy, expectedResult := range expectedResults {
+		m := missingSpec{policy: policy}
+		r, err := getRollingSpec("mean(c0, 2)", 0, policy == skipMissing)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for i, v := range rows {
+			row, err := r.add([]string{v}, nil, m)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if row[1] != expectedResult[i] {
+				t.Errorf("expected %s and computed %s rolling mean in row %d with "+
+					"policy %d don't match", expectedResult[i], row[1], i, policy)
+			}
+		}
+	}
+
+	for _, spec := range []string{"mean(c0)", "cumsum(c0, 3)", "foo(c0, 2)", "mean(c3, 2)",
+		"mean(x, 2)"} {
+		if _, err := getRollingSpec(spec, 1, false); err == nil {
+			t.Errorf("expected an error for rolling statistic %s", spec)
+		}
+	}
+}
+
+// Test_groupBy checks the group-by aggregation in streaming mode and in hash
+// mode after a key out of order
+func Test_groupBy(t *testing.T) {
+
+	unsorted := [][]string{[]string{"A", "1", "3"}, []string{"B", "2", "NA"},
+		[]string{"A", "5", "7"}, []string{"1.0", "4", "4"}, []string{"1", "6", "6"}}
+	sorted := [][]string{[]string{"1.0", "4", "4"}, []string{"1", "6", "6"},
+		[]string{"A", "1", "3"}, []string{"A", "5", "7"}, []string{"B", "2", "NA"}}
+	inputs := [][][]string{unsorted, sorted}
+	// the group printed before the first key out of order is not merged
+	expectedResults := []string{"A 2 2\nB 2 1\nA 6 2\n1.0 5 4\n",
+		"1.0 5 4\nA 4 4\nB 2 1\n"}
+	for i, rows := range inputs {
+		g, err := getGroupSpec("0", "", 3, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if g.actions, err = getComputeSpecs("mean,count"); err != nil {
+			t.Error(err)
+			return
+		}
+		if g.formats, err = getNumberFormats(shortestFormat,
+			actionNames("mean,count")); err != nil {
+			t.Error(err)
+			return
+		}
+		g.missing = missingSpec{policy: skipMissing}
+
+		var buf bytes.Buffer
+		output := newRowWriter(plainOutput, &buf, " ")
+		for _, r := range rows {
+			if err := g.add(output, r, nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if sorted := i == 1; sorted != (g.groups == nil) {
+			t.Errorf("expected streaming mode %v for input %d", sorted, i)
+		}
+		if err := g.flush(output); err != nil {
+			t.Error(err)
+			return
+		}
+		output.flush()
+		if buf.String() != expectedResults[i] {
+			t.Errorf("expected %q and computed %q groups don't match", expectedResults[i],
+				buf.String())
+		}
+	}
+
+	if _, err := getGroupSpec("0", "3", 3, nil); err == nil {
+		t.Error("failed to reject out of bounds target column")
+	}
+}
+
+// Test_outputFormats checks that rows are written properly quoted in each of
+// the output formats
+func Test_outputFormats(t *testing.T) {
+
+	header := []string{"name", "value", "note"}
+	rows := [][]string{[]string{"a,b", "1.50", `say "hi"`}, []string{"c|d", "NaN", "x\ty"}}
+	expectedResults := map[outputFormat]string{
+		plainOutput: "name,value,note\na,b,1.50,say \"hi\"\nc|d,NaN,x\ty\n",
+		csvOutput:   "name,value,note\n\"a,b\",1.50,\"say \"\"hi\"\"\"\nc|d,NaN,x\ty\n",
+		tsvOutput:   "name\tvalue\tnote\na,b\t1.50\t\"say \"\"hi\"\"\"\nc|d\tNaN\t\"x\ty\"\n",
+		jsonOutput: `{"name":"a,b","value":1.50,"note":"say \"hi\""}` + "\n" +
+			`{"name":"c|d","value":"NaN","note":"x\ty"}` + "\n",
+		markdownOutput: "| name | value | note |\n| --- | --- | --- |\n" +
+			"| a,b | 1.50 | say \"hi\" |\n| c\\|d | NaN | x\ty |\n",
+		tableOutput: "name  value  note\na,b   1.50   say \"hi\"\nc|d   NaN    x y\n",
+	}
+
+	for format, expected := range expectedResults {
+		var buf bytes.Buffer
+		output := newRowWriter(format, &buf, ",")
+		if err := output.writeHeader(header); err != nil {
+			t.Error(err)
+			return
+		}
+		for _, r := range rows {
+			if err := output.writeRow(r); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if err := output.flush(); err != nil {
+			t.Error(err)
+			return
+		}
+		if buf.String() != expected {
+			t.Errorf("expected %q and computed %q output in format %d don't match",
+				expected, buf.String(), format)
+		}
+	}
+
+	// without header JSON keys and Markdown column names are generated
+	var buf bytes.Buffer
+	output := newRowWriter(jsonOutput, &buf, " ")
+	output.writeRow([]string{"x", "2"})
+	output.flush()
+	if expected := `{"c0":"x","c1":2}` + "\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q JSON output don't match", expected,
+			buf.String())
+	}
+
+	// only valid JSON numbers are written as numbers and keys are unique
+	buf.Reset()
+	output = newRowWriter(jsonOutput, &buf, " ")
+	output.writeHeader([]string{"name", "name", "c3"})
+	output.writeRow([]string{"007", "1e3", " -0.5 ", ".5", "+1", "0x10", "Inf", "1."})
+	output.flush()
+	expected := `{"name":"007","name_2":1e3,"c3":-0.5,"c3_2":".5","c4":"+1",` +
+		`"c5":"0x10","c6":"Inf","c7":"1."}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q and computed %q JSON output don't match", expected,
+			buf.String())
+	}
+
+	if _, err := parseOutputFormat("xml"); err == nil {
+		t.Error("failed to reject unknown output format")
+	}
+}
+
+// Test_pipelineRun checks that a Pipeline pastes and computes rows from the
+// provided inputs and that invalid specs are reported per field
+func Test_pipelineRun(t *testing.T) {
+
+	p, err := NewPipeline(Spec{Input: "0|1", Compute: "sum", AppendCols: true,
+		Header: true, OutputSep: " ", Format: "shortest"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = p.Run(&buf, Input{"a", strings.NewReader("x y\n1 2\n3 4\n")},
+		Input{"b", strings.NewReader("u v\n5 6\n7 8\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "x v sum\n1 6 7\n3 8 11\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected, buf.String())
+	}
+
+	// summary rows are labeled with their action
+	p, err = NewPipeline(Spec{Input: "0|1", Compute: "sum,max", Vertical: true,
+		Header: true, OutputSep: " ", Format: "shortest"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	err = p.Run(&buf, Input{"a", strings.NewReader("x y\n1 2\n3 4\n")},
+		Input{"b", strings.NewReader("u v\n5 6\n7 8\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "action x v\nsum 4 14\nmax 3 8\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected, buf.String())
+	}
+
+	// the zero value of all other fields selects the defaults of pst except
+	// for the output separator, which is empty like with -t ""
+	if p, err = NewPipeline(Spec{Input: "0|1"}); err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	err = p.Run(&buf, Input{"a", strings.NewReader("1 2\n3 4\n")},
+		Input{"b", strings.NewReader("5 6\n7 8\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "16\n38\n"; buf.String() != expected {
+		t.Errorf("expected %q and computed %q output don't match", expected, buf.String())
+	}
+
+	_, err = NewPipeline(Spec{Compute: "mean,foo"})
+	if e, ok := err.(*SpecError); !ok || e.Field != "Compute" {
+		t.Errorf("expected a SpecError for field Compute but got %v", err)
+	}
+	_, err = NewPipeline(Spec{Output: "0"})
+	if e, ok := err.(*SpecError); !ok || e.Field != "Output" {
+		t.Errorf("expected a SpecError for field Output but got %v", err)
+	}
+}
+
+// Test_errorLines checks that errors name the line of the input file the
+// offending value was read from
+func Test_errorLines(t *testing.T) {
+
+	a := "x 1\ny 2\nz NA\n"
+	for _, test := range []struct {
+		spec     Spec
+		inputs   []string
+		expected string
+	}{
+		{Spec{Input: "1", Rows: "1-", Compute: "mean"}, []string{a},
+			"line 3 of file 0 column 1"},
+		{Spec{Input: "1", Rows: "1-", Header: true, Compute: "mean"}, []string{a},
+			"line 3 of file 0 column 1"},
+		{Spec{Input: "1", Keys: "0", Compute: "mean"},
+			[]string{"1 5\n2 6\n3 NA\n", "3 7\n1 8\n"}, "line 3 of file 0 column 1"},
+		{Spec{Input: "0|0", Compute: "sum"}, []string{"1\n2\n", "3 4\nNA 5\n"},
+			"line 2 of file 1 column 0"},
+		{Spec{Input: "1", Filter: "c0 > 1"}, []string{a}, "line 3 of file 0"},
+	} {
+		p, err := NewPipeline(test.spec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var inputs []Input
+		for i, in := range test.inputs {
+			inputs = append(inputs, Input{strconv.Itoa(i), strings.NewReader(in)})
+		}
+		err = p.Run(io.Discard, inputs...)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected error naming %s but got %v", test.expected, err)
+		}
+	}
+}
+
+// Test_pipelineReader checks that output rows can be read one by one and
+// that closing a Reader early stops processing
+func Test_pipelineReader(t *testing.T) {
+
+	p, err := NewPipeline(Spec{Input: "1,0"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	r, err := p.Open(Input{"a", strings.NewReader("1 2\n3 4\n")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Close()
+
+	expected := [][]string{[]string{"2", "1"}, []string{"4", "3"}}
+	for _, e := range expected {
+		row, err := r.Read()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !stringsIdentical(row, e) {
+			t.Errorf("expected %v and computed %v rows don't match", e, row)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the output but got %v", err)
+	}
+
+	// closing the reader before all rows are read must not block
+	var input bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		input.WriteString("1 2\n")
+	}
+	r, err = p.Open(Input{"b", &input})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := r.Read(); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+}
+
+// Test_registerComputeAction checks that custom compute actions can be used
+// for row and column statistics
+func Test_registerComputeAction(t *testing.T) {
+
+	first := func(x []float64) float64 { return x[0] }
+	if err := RegisterComputeAction("first", first); err != nil {
+		t.Error(err)
+		return
+	}
+	t.Cleanup(func() { unregisterAction("first") })
+
+	for vertical, expected := range map[bool]string{false: "1\n3\n", true: "first 1 2\n"} {
+		p, err := NewPipeline(Spec{Input: "0-1", Compute: "first", Vertical: vertical,
+			OutputSep: " ", Format: "shortest"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := p.Run(&buf, Input{"a", strings.NewReader("1 2\n3 4\n")}); err != nil {
+			t.Error(err)
+			return
+		}
+		if buf.String() != expected {
+			t.Errorf("expected %q and computed %q output don't match", expected, buf.String())
+		}
+	}
+}
+
+// Test_actionRegistry checks parameterized compute actions and the
+// registration of custom actions with parameters
+func Test_actionRegistry(t *testing.T) {
+
+	data := []float64{10, 1, 2, 3, 4, 5, 6, 7, 8, -20}
+	expectedResult := []float64{4.5, 4.5, 2.6, 9.1, 9.1, 9.1, 2.6}
+	if _, err := getComputeSpecs("scaled(2, 0.5)"); err == nil {
+		t.Error("failed to reject unregistered compute action")
+	}
+
+	scaled := ActionInfo{Name: "scaled", Description: "scaled mean",
+		Params: []string{"a", "b"},
+		New: func(params []float64) (ComputeAction, error) {
+			return func(x []float64) float64 { return params[0] * mean(x) * params[1] }, nil
+		}}
+	if err := RegisterAction(scaled); err != nil {
+		t.Error(err)
+		return
+	}
+	t.Cleanup(func() { unregisterAction("scaled") })
+	if err := RegisterAction(scaled); err == nil {
+		t.Error("failed to reject duplicate compute action")
+	}
+
+	actions, err := getComputeSpecs("trim(0.1), trim(0.2), trim(0), p(95), p95, " +
+		"p( 95 ), scaled(2, 0.5)")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, a := range actions {
+		if r := a(data); math.Abs(r-expectedResult[i]) > 1e-12 {
+			t.Errorf("expected %v and computed %v don't match for action #%d",
+				expectedResult[i], r, i)
+		}
+	}
+
+	for _, bad := range []string{"trim", "trim(0.5)", "trim(x)", "mean(1)", "scaled(1)",
+		"p(1, 2)"} {
+		if _, err := getComputeSpecs(bad); err == nil {
+			t.Errorf("failed to reject invalid compute action %s", bad)
+		}
+	}
+
+	found := false
+	for _, info := range Actions() {
+		if info.Name == "scaled" && info.Usage() == "scaled(a, b)" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("registered compute action scaled is not listed")
+	}
+}
+
+// Test_chunkedReader checks that inputs tokenized in blocks by a lineReader
+// and in parallel chunks by a chunkedReader yield the same records in the
+// same order as splitting each line separately
+func Test_chunkedReader(t *testing.T) {
+
+	var long bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		long.WriteString(strconv.Itoa(i) + " " + strings.Repeat("x", i%50) + "\n")
+	}
+	inputs := []string{"", "1 2\n", "1 2", "a b\r\nc d\r\n\n e  f \n", long.String(),
+		strings.Repeat("y", 100) + "\nz", "\u00a0a\u2003b\xffc \n"}
+
+	// readAll returns all records of r
+	readAll := func(r recordReader) ([][]string, error) {
+		var records [][]string
+		for {
+			items, err := r.Read()
+			if err == io.EOF {
+				return records, nil
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, items)
+		}
+	}
+
+	defer func(size int) { chunkSize = size }(chunkSize)
+	sepFun := getInputSepFunc("")
+	for _, size := range []int{1, 7, 64, 1 << 20} {
+		chunkSize = size
+		for _, input := range inputs {
+			var expected [][]string
+			scanner := bufio.NewScanner(strings.NewReader(input))
+			for scanner.Scan() {
+				expected = append(expected, strings.Fields(scanner.Text()))
+			}
+
+			seq, err := readAll(newLineReader(strings.NewReader(input), sepFun, false, 0))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			c := newChunkedReader(strings.NewReader(input), sepFun, false, 3, 0)
+			computed, err := readAll(c)
+			c.Close()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for _, records := range [][][]string{seq, computed} {
+				if len(expected) != len(records) {
+					t.Errorf("expected %d and computed %d records for chunk size %d "+
+						"don't match", len(expected), len(records), size)
+					continue
+				}
+				for i := range expected {
+					if !stringsIdentical(expected[i], records[i]) || records[i] == nil {
+						t.Errorf("expected %v and computed %v records for chunk size %d "+
+							"don't match", expected[i], records[i], size)
+					}
+				}
+			}
+		}
+	}
+}
+
+// tempInput writes content to a file in a temporary directory and returns
+// it opened as an Input which is closed at the end of the test
+func tempInput(t *testing.T, name, content string) Input {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return Input{name, file}
+}
+
+// Test_chunkable checks that only regular files of sufficient size are
+// tokenized in chunks
+func Test_chunkable(t *testing.T) {
+
+	defer func(size int64) { minChunkedSize = size }(minChunkedSize)
+	minChunkedSize = 10
+
+	if chunkable(strings.NewReader("1 2 3 4 5 6 7 8 9 10")) {
+		t.Error("a strings.Reader should not be chunked")
+	}
+	if in := tempInput(t, "small", "1 2 3\n"); chunkable(in.Reader) {
+		t.Error("a file below the minimum size should not be chunked")
+	}
+	if in := tempInput(t, "large", "1 2 3 4 5 6\n"); !chunkable(in.Reader) {
+		t.Error("a file above the minimum size should be chunked")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Close()
+	defer w.Close()
+	if chunkable(r) {
+		t.Error("a pipe should not be chunked")
+	}
+}
+
+// Test_pipelineWorkers checks that pasting with several workers per file
+// keeps the row order and stops cleanly when rows are left unread
+func Test_pipelineWorkers(t *testing.T) {
+
+	defer func(size int) { chunkSize = size }(chunkSize)
+	defer func(size int64) { minChunkedSize = size }(minChunkedSize)
+	chunkSize, minChunkedSize = 16, 0
+
+	var input, expected bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&input, "%d %d\n", i, 2*i)
+		fmt.Fprintf(&expected, "%d %d\n", 2*i, i)
+	}
+
+	p, err := NewPipeline(Spec{Input: "1,0", OutputSep: " ", Workers: 4})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := p.Run(&buf, tempInput(t, "a", input.String())); err != nil {
+		t.Error(err)
+		return
+	}
+	if buf.String() != expected.String() {
+		t.Error("rows tokenized by several workers are out of order")
+	}
+
+	p, err = NewPipeline(Spec{Input: "0", Rows: "2-3", OutputSep: " ", Workers: 4})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	if err := p.Run(&buf, tempInput(t, "a", input.String())); err != nil {
+		t.Error(err)
+		return
+	}
+	if buf.String() != "2\n3\n" {
+		t.Errorf("expected %q and computed %q output don't match", "2\n3\n", buf.String())
+	}
+}
+
+// Test_longLines checks that lines longer than the default bufio.Scanner
+// buffer are read and that the optional maximum line length is enforced
+func Test_longLines(t *testing.T) {
+
+	defer func(size int) { chunkSize = size }(chunkSize)
+	defer func(size int64) { minChunkedSize = size }(minChunkedSize)
+	chunkSize, minChunkedSize = 1000, 0
+
+	wide := strings.Repeat("1.5 ", 50000) + "7\n"
+	input := "1 2\n" + wide + "3 4\r\n"
+	for _, workers := range []int{1, 3} {
+		p, err := NewPipeline(Spec{Input: "0", OutputSep: " ", Workers: workers})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := p.Run(&buf, tempInput(t, "wide", input)); err != nil {
+			t.Error(err)
+			continue
+		}
+		if expected := "1\n1.5\n3\n"; buf.String() != expected {
+			t.Errorf("expected %q and computed %q output don't match", expected,
+				buf.String())
+		}
+
+		p, err = NewPipeline(Spec{Input: "0", OutputSep: " ", Workers: workers,
+			MaxLine: 1000})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = p.Run(&buf, tempInput(t, "wide", input))
+		if err == nil || !strings.Contains(err.Error(), "file wide") ||
+			!strings.Contains(err.Error(), "line 2 ") {
+			t.Errorf("expected an error naming file wide and line 2 but got %v", err)
+		}
+
+		// lines of exactly the maximum length are accepted
+		p, err = NewPipeline(Spec{Input: "0", OutputSep: " ", Workers: workers,
+			MaxLine: 3})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := p.Run(&buf, tempInput(t, "short", "1 2\r\n3 4")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := NewPipeline(Spec{MaxLine: -1}); err == nil {
+		t.Error("failed to reject negative maximum line length")
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Spec describes what to parse and how to assemble the output. Each field
+// corresponds to a command line option of pst and accepts the same syntax.
+// Empty policies and formats select the defaults of the command line tool.
+type Spec struct {
+	Input      string // input columns per file (-i)
+	Output     string // output column order (-o)
+	InputSep   string // input column separator, whitespace if empty (-s)
+	OutputSep  string // output column separator, columns are adjacent if empty (-t)
+	Compute    string // compute actions (-c)
+	Rows       string // rows to process (-r)
+	CSV        bool   // parse the inputs as RFC 4180 csv (-csv)
+	Header     bool   // the first line of each input is a header (-header)
+	Keys       string // key column per file (-k)
+	Join       string // type of key join, inner if empty (-join)
+	Sorted     bool   // no effect, sorted inputs are detected automatically (-sorted)
+	Uneven     string // policy for inputs of different lengths, pad if empty (-uneven)
+	Fill       string // fill value for missing columns (-fill)
+	Vertical   bool   // compute the actions column wise (-vertical)
+	AppendCols bool   // print the output columns before the computed values (-append)
+	Format     string // number format of computed values (-f)
+	Missing    string // policy for missing values, fail if empty (-missing)
+	Exprs      string // output column expressions (-e)
+	Filter     string // row filter expression (-filter)
+	Rolling    string // windowed and running statistics (-rolling)
+	GroupBy    string // group-by key columns (-groupby)
+	Target     string // group-by target columns (-target)
+	OutFormat  string // output format, plain if empty (-outformat)
+	Workers    int    // workers tokenizing each line oriented input in chunks (-n)
+	MaxLine    int    // maximum input line length in bytes, unlimited if 0 (-maxline)
+}
+
+// SpecError describes an invalid field of a Spec. Field is the name of the
+// offending Spec field.
+type SpecError struct {
+	Field string
+	Err   error
+}
+
+// Error returns the message of the underlying error
+func (e *SpecError) Error() string {
+	return e.Err.Error()
+}
+
+// specError wraps a non-nil error into a SpecError for the given field
+func specError(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &SpecError{Field: field, Err: err}
+}
+
+// Input is a named input stream. The name is used in error messages and to
+// qualify header names. Compressed streams are decompressed transparently.
+type Input struct {
+	Name   string
+	Reader io.Reader
+}
+
+// Pipeline processes inputs according to a validated Spec. A Pipeline can
+// be run several times, but not concurrently.
+type Pipeline struct {
+	spec       Spec
+	newReader  recordReaderFunc
+	newChunked recordReaderFunc // for large regular files, nil if not used
+	rowRanges  []rowRange
+}
+
+// NewPipeline checks all parts of the spec which do not depend on the
+// inputs and returns a Pipeline for it. Invalid fields are reported as a
+// *SpecError.
+func NewPipeline(spec Spec) (*Pipeline, error) {
+
+	// an output spec requires a valid input spec
+	if spec.Output != "" && spec.Input == "" {
+		return nil, specError("Output",
+			errors.New("an output paste spec requires an input column spec"))
+	}
+
+	// as does a key join
+	if spec.Keys != "" && spec.Input == "" {
+		return nil, specError("Keys", errors.New("a key join requires an input column spec"))
+	}
+
+	// expressions replace the output spec
+	if spec.Exprs != "" && spec.Output != "" {
+		return nil, specError("Exprs",
+			errors.New("output expressions and an output paste spec are mutually exclusive"))
+	}
+
+	if spec.Vertical && spec.Compute == "" {
+		return nil, specError("Vertical",
+			errors.New("column wise statistics require compute actions"))
+	}
+
+	if spec.GroupBy != "" && (spec.Compute == "" || spec.Vertical) {
+		return nil, specError("GroupBy",
+			errors.New("group-by aggregation requires row compute actions without vertical"))
+	}
+
+	p := &Pipeline{spec: spec}
+	var err error
+	if spec.MaxLine < 0 {
+		return nil, specError("MaxLine", fmt.Errorf("invalid maximum line length %d",
+			spec.MaxLine))
+	}
+	if p.newReader, err = getRecordReaderFunc(spec.InputSep, spec.CSV,
+		spec.Input == "", spec.MaxLine); err != nil {
+		return nil, specError("InputSep", err)
+	}
+	// quoted csv fields can contain newlines and are thus parsed sequentially
+	if spec.Workers > 1 && !spec.CSV {
+		p.newChunked = getChunkedReaderFunc(spec.InputSep, spec.Input == "", spec.Workers,
+			spec.MaxLine)
+	}
+	if p.rowRanges, err = getRowSpec(spec.Rows); err != nil {
+		return nil, specError("Rows", err)
+	}
+	if _, err = parseOutputFormat(spec.OutFormat); err != nil {
+		return nil, specError("OutFormat", err)
+	}
+	if spec.Vertical {
+		_, err = getColumnSummary(spec.Compute)
+	} else {
+		_, err = getComputeSpecs(spec.Compute)
+	}
+	if err != nil {
+		return nil, specError("Compute", err)
+	}
+	if _, err = getNumberFormats(spec.Format, actionNames(spec.Compute)); err != nil {
+		return nil, specError("Format", err)
+	}
+	if _, err = getMissingSpec(spec.Missing, nil, nil, nil); err != nil {
+		return nil, specError("Missing", err)
+	}
+	if _, err = getPasteSpec(spec.Uneven, spec.Fill, nil, nil); err != nil {
+		return nil, specError("Uneven", err)
+	}
+	if spec.Keys != "" {
+		if _, err = parseJoinType(spec.Join); err != nil {
+			return nil, specError("Join", err)
+		}
+	}
+
+	// column references are checked once the inputs are known
+	missing, _ := getMissingSpec(spec.Missing, nil, nil, nil)
+	if spec.Exprs != "" {
+		if _, _, err = parseExprList(spec.Exprs, -1, missing); err != nil {
+			return nil, specError("Exprs", err)
+		}
+	}
+	if spec.Filter != "" {
+		if _, err = parseExpr(spec.Filter, -1, missing); err != nil {
+			return nil, specError("Filter", err)
+		}
+	}
+	if spec.Rolling != "" {
+		if _, err = getRollingSpec(spec.Rolling, -1, false); err != nil {
+			return nil, specError("Rolling", err)
+		}
+	}
+	return p, nil
+}
+
+// Run processes the inputs and writes the output to w. The inputs are
+// pasted or joined in the order given.
+func (p *Pipeline) Run(w io.Writer, inputs ...Input) error {
+
+	r, err := p.setup(inputs)
+	if err != nil {
+		return err
+	}
+	defer closeInputs(r.inputs)
+
+	return r.process(newRowWriter(r.out.format, w, r.out.sep))
+}
+
+// pipelineRun holds the state of a single run of a Pipeline
+type pipelineRun struct {
+	inputs    []*inputFile
+	inCols    []ParseSpec
+	rowRanges []rowRange
+	paste     pasteSpec
+	join      *joinSpec
+	out       outputSpec
+}
+
+// process parses the inputs and writes the output rows to output
+func (r *pipelineRun) process(output rowWriter) error {
+	return parseData(r.inputs, r.inCols, r.rowRanges, r.paste, r.join, r.out, output)
+}
+
+// setup opens the inputs and resolves the parts of the spec which depend
+// on their headers and number of columns
+func (p *Pipeline) setup(sources []Input) (*pipelineRun, error) {
+
+	spec := p.spec
+	if len(sources) == 0 {
+		return nil, errors.New("no inputs provided")
+	}
+
+	inputs, err := openInputs(sources, p.newReader, p.newChunked, spec.Header)
+	if err != nil {
+		return nil, err
+	}
+	r, err := p.resolve(inputs)
+	if err != nil {
+		closeInputs(inputs)
+		return nil, err
+	}
+	return r, nil
+}
+
+// resolve assembles a pipelineRun for the opened inputs
+func (p *Pipeline) resolve(inputs []*inputFile) (*pipelineRun, error) {
+
+	spec := p.spec
+	r := &pipelineRun{inputs: inputs, rowRanges: p.rowRanges}
+
+	var headers [][]string
+	if spec.Header {
+		headers = make([][]string, len(inputs))
+		for i, in := range inputs {
+			headers[i] = in.header
+		}
+	}
+	var err error
+	if r.inCols, err = getInputSpec(spec.Input, len(inputs), headers,
+		inputWidths(inputs)); err != nil {
+		return nil, specError("Input", err)
+	}
+
+	totNumCols := totalLen(r.inCols)
+	var colNames []string
+	if spec.Header {
+		if colNames, err = getColumnNames(inputs, r.inCols); err != nil {
+			return nil, specError("Input", err)
+		}
+	}
+	outCols, err := getOutputSpec(spec.Output, totNumCols, colNames, r.inCols, inputs)
+	if err != nil {
+		return nil, specError("Output", err)
+	}
+
+	out := outputSpec{cols: outCols, sep: spec.OutputSep, appendCols: spec.AppendCols}
+	if out.format, err = parseOutputFormat(spec.OutFormat); err != nil {
+		return nil, specError("OutFormat", err)
+	}
+	// the number of columns is only known if they are selected explicitly
+	maxCol := totNumCols - 1
+	if spec.Input == "" {
+		maxCol = -1
+	}
+	if out.missing, err = getMissingSpec(spec.Missing, inputs, r.inCols, outCols); err != nil {
+		return nil, specError("Missing", err)
+	}
+	var exprSources []string
+	if spec.Exprs != "" {
+		if out.exprs, exprSources, err = parseExprList(spec.Exprs, maxCol, out.missing); err != nil {
+			return nil, specError("Exprs", err)
+		}
+	}
+	if spec.Filter != "" {
+		if out.filter, err = parseExpr(spec.Filter, maxCol, out.missing); err != nil {
+			return nil, specError("Filter", err)
+		}
+	}
+
+	rowActions := spec.Compute
+	if spec.Vertical {
+		if out.summary, err = getColumnSummary(spec.Compute); err != nil {
+			return nil, specError("Compute", err)
+		}
+		rowActions = ""
+	} else if out.actions, err = getComputeSpecs(spec.Compute); err != nil {
+		return nil, specError("Compute", err)
+	}
+
+	if out.formats, err = getNumberFormats(spec.Format, actionNames(spec.Compute)); err != nil {
+		return nil, specError("Format", err)
+	}
+	if out.exprs != nil {
+		out.missing.origins, out.missing.files = nil, nil
+		for _, src := range exprSources {
+			out.missing.origins = append(out.missing.origins, "expression "+src)
+		}
+	}
+	// the rolling statistics and groups refer to the columns of the output row
+	maxOutCol := maxCol
+	if out.exprs != nil {
+		maxOutCol = len(out.exprs) - 1
+	} else if len(outCols) != 0 {
+		maxOutCol = len(outCols) - 1
+	}
+	if spec.Rolling != "" {
+		if out.rolling, err = getRollingSpec(spec.Rolling, maxOutCol,
+			out.missing.policy == skipMissing); err != nil {
+			return nil, specError("Rolling", err)
+		}
+	}
+
+	var rowNames []string
+	if spec.Header {
+		names, cols := colNames, outCols
+		if out.exprs != nil {
+			names, cols = exprSources, nil
+		}
+		rowNames = getOutputHeader(names, cols, "", false)
+		if out.rolling != nil {
+			rowNames = append(rowNames, out.rolling.names...)
+		}
+	}
+
+	if spec.GroupBy != "" {
+		numOutCols := -1
+		if maxOutCol >= 0 {
+			numOutCols = maxOutCol + 1
+			if out.rolling != nil {
+				numOutCols += len(out.rolling.stats)
+			}
+		}
+		if out.groups, err = getGroupSpec(spec.GroupBy, spec.Target, numOutCols,
+			rowNames); err != nil {
+			return nil, specError("GroupBy", err)
+		}
+		out.groups.actions = out.actions
+		out.groups.formats = out.formats
+		out.groups.missing = out.missing
+	}
+	if out.summary != nil {
+		out.summary.formats = out.formats
+		out.summary.missing = out.missing
+	}
+
+	if r.paste, err = getPasteSpec(spec.Uneven, spec.Fill, inputs, r.inCols); err != nil {
+		return nil, specError("Uneven", err)
+	}
+
+	if spec.Keys != "" {
+		if r.join, err = getJoinSpec(spec.Keys, spec.Join, inputs, r.inCols,
+			headers); err != nil {
+			return nil, specError("Keys", err)
+		}
+		r.join.fill = spec.Fill
+	}
+
+	if spec.Header {
+		if out.summary != nil {
+			// summary rows start with the name of their action
+			out.header = append([]string{"action"}, rowNames...)
+		} else if out.groups != nil {
+			keyNames := getOutputHeader(rowNames, out.groups.keys, "", false)
+			out.header = getOutputHeader(keyNames, nil, rowActions, true)
+		} else {
+			out.header = getOutputHeader(rowNames, nil, rowActions, spec.AppendCols)
+		}
+	}
+	r.out = out
+	return r, nil
+}
+
+// Reader streams the output rows of a Pipeline. Rows are produced in the
+// background while the caller reads them.
+type Reader struct {
+	header []string
+	rows   chan []string
+	done   chan struct{}
+	err    error // set before rows is closed
+	close  sync.Once
+}
+
+// Open starts processing the inputs and returns a Reader for the output
+// rows. The Reader has to be closed once it is no longer needed.
+func (p *Pipeline) Open(inputs ...Input) (*Reader, error) {
+
+	r, err := p.setup(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	rd := &Reader{header: r.out.header, rows: make(chan []string, 100),
+		done: make(chan struct{})}
+	go func() {
+		defer closeInputs(r.inputs)
+		rd.err = r.process(&channelWriter{rows: rd.rows, done: rd.done})
+		close(rd.rows)
+	}()
+	return rd, nil
+}
+
+// Header returns the header of the output if the Spec requested one
+func (rd *Reader) Header() []string {
+	return rd.header
+}
+
+// Read returns the next output row. At the end of the output it returns
+// io.EOF or the error which stopped processing.
+func (rd *Reader) Read() ([]string, error) {
+	if row, ok := <-rd.rows; ok {
+		return row, nil
+	}
+	if rd.err != nil {
+		return nil, rd.err
+	}
+	return nil, io.EOF
+}
+
+// Close stops processing and waits for it to shut down
+func (rd *Reader) Close() error {
+	rd.close.Do(func() {
+		close(rd.done)
+		for range rd.rows {
+		}
+	})
+	return nil
+}
+
+// errReaderClosed signals the background processing that its Reader was
+// closed
+var errReaderClosed = errors.New("reader was closed")
+
+// channelWriter is a rowWriter sending the output rows to a Reader
+type channelWriter struct {
+	rows chan<- []string
+	done <-chan struct{}
+}
+
+// writeHeader does nothing since the header is provided via Reader.Header
+func (c *channelWriter) writeHeader(header []string) error {
+	return nil
+}
+
+// writeRow sends a copy of the row since rows are reused by processData
+func (c *channelWriter) writeRow(row []string) error {
+	r := make([]string, len(row))
+	copy(r, row)
+	select {
+	case c.rows <- r:
+		return nil
+	case <-c.done:
+		return errReaderClosed
+	}
+}
+
+// flush does nothing since all rows are sent as soon as they are written
+func (c *channelWriter) flush() error {
+	return nil
+}
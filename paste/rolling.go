@@ -0,0 +1,304 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rollingAction consumes the value of its column in the next row and returns
+// the statistic over the current window or over all rows seen so far
+type rollingAction func(v float64) float64
+
+// rollingStat is a windowed or running statistic of a single output column
+type rollingStat struct {
+	col    int
+	action rollingAction
+}
+
+// rollingSpec describes the windowed and running statistics which are
+// appended as columns to each output row
+type rollingSpec struct {
+	stats []rollingStat
+	names []string // source of each statistic used for the header
+}
+
+// rollingRegexp matches a single windowed or running statistic such as
+// "mean(c1, 5)" or "cumsum(c2)"
+var rollingRegexp = regexp.MustCompile(`^([a-z]+)\(\s*(c[0-9]+)\s*(?:,\s*([0-9]+)\s*)?\)$`)
+
+// getRollingSpec parses the comma separated list of windowed and running
+// statistics. maxCol is the largest valid output column index or -1 if the
+// number of columns is not known. If skipNaN is set missing values and NaN
+// are ignored, otherwise they propagate to the statistics.
+func getRollingSpec(input string, maxCol int, skipNaN bool) (*rollingSpec, error) {
+
+	var r rollingSpec
+	for _, item := range splitArguments(input) {
+		src := strings.TrimSpace(item)
+		m := rollingRegexp.FindStringSubmatch(src)
+		if m == nil {
+			return nil, fmt.Errorf("invalid rolling statistic %q, expected e.g. "+
+				"mean(c1, 5) or cumsum(c1)", src)
+		}
+
+		col, _ := columnIndex(m[2])
+		if maxCol >= 0 && col > maxCol {
+			return nil, fmt.Errorf("rolling statistic %s refers to column %d but there "+
+				"are only %d output columns", src, col, maxCol+1)
+		}
+
+		window := 0
+		if m[3] != "" {
+			window, _ = strconv.Atoi(m[3])
+		}
+		action, err := newRollingAction(m[1], window, skipNaN)
+		if err != nil {
+			return nil, fmt.Errorf("rolling statistic %s: %s", src, err)
+		}
+		r.stats = append(r.stats, rollingStat{col, action})
+		r.names = append(r.names, src)
+	}
+	return &r, nil
+}
+
+// splitArguments splits a comma separated list at all commas which are not
+// enclosed in parentheses
+func splitArguments(input string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range input {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, input[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, input[start:])
+}
+
+// newRollingAction creates the named statistic. The windowed statistics
+// mean, std, var, min, max, and median require a window size in rows while
+// the running statistics cumsum and runmean are computed over all rows.
+func newRollingAction(name string, window int, skipNaN bool) (rollingAction, error) {
+
+	switch name {
+	case "cumsum", "runmean":
+		if window != 0 {
+			return nil, fmt.Errorf("%s does not take a window size", name)
+		}
+	case "mean", "std", "var", "min", "max", "median":
+		if window < 1 {
+			return nil, fmt.Errorf("%s requires a positive window size", name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown statistic %s, expected one of mean, std, var, "+
+			"min, max, median, cumsum, or runmean", name)
+	}
+
+	var stat windowStat
+	switch name {
+	case "cumsum":
+		var total float64
+		return func(v float64) float64 {
+			if !(skipNaN && math.IsNaN(v)) {
+				total += v
+			}
+			return total
+		}, nil
+	case "runmean":
+		var r runningStats
+		return func(v float64) float64 {
+			if !(skipNaN && math.IsNaN(v)) {
+				r.update(v)
+			}
+			return r.mean()
+		}, nil
+	case "mean":
+		stat = &slidingMoments{result: (*slidingMoments).mean}
+	case "std":
+		stat = &slidingMoments{result: func(m *slidingMoments) float64 {
+			return math.Sqrt(m.variance())
+		}}
+	case "var":
+		stat = &slidingMoments{result: (*slidingMoments).variance}
+	case "min":
+		stat = &slidingExtremum{less: func(x, y float64) bool { return x < y }}
+	case "max":
+		stat = &slidingExtremum{less: func(x, y float64) bool { return x > y }}
+	case "median":
+		stat = &slidingMedian{newMedData()}
+	}
+	return windowed(window, skipNaN, stat), nil
+}
+
+// windowStat is a statistic which is updated as values enter and leave a
+// sliding window. Values are removed in the order in which they were added.
+type windowStat interface {
+	add(v float64)
+	remove(v float64)
+	value() float64
+}
+
+// windowed turns a windowStat into a rollingAction over a window of size
+// rows. The result is NaN until the window is full. NaN values occupy their
+// row in the window but are not passed on to the windowStat. Unless skipNaN
+// is set they turn the result into NaN as long as they are within the window.
+func windowed(size int, skipNaN bool, stat windowStat) rollingAction {
+
+	values := make([]float64, 0, size)
+	next, numNaN := 0, 0
+	return func(v float64) float64 {
+		if len(values) == size {
+			if old := values[next]; math.IsNaN(old) {
+				numNaN--
+			} else {
+				stat.remove(old)
+			}
+			values[next] = v
+		} else {
+			values = append(values, v)
+		}
+		next = (next + 1) % size
+
+		if math.IsNaN(v) {
+			numNaN++
+		} else {
+			stat.add(v)
+		}
+
+		if len(values) < size || numNaN == size || (numNaN > 0 && !skipNaN) {
+			return math.NaN()
+		}
+		return stat.value()
+	}
+}
+
+// slidingMoments computes the mean and variance of the values in a window.
+// Values are added and removed via Welford's update and its inverse.
+type slidingMoments struct {
+	n      int
+	mk, qk float64
+	result func(*slidingMoments) float64
+}
+
+func (m *slidingMoments) add(v float64) {
+	m.n++
+	delta := v - m.mk
+	m.mk += delta / float64(m.n)
+	m.qk += delta * (v - m.mk)
+}
+
+func (m *slidingMoments) remove(v float64) {
+	if m.n == 1 {
+		m.n, m.mk, m.qk = 0, 0, 0
+		return
+	}
+	delta := v - m.mk
+	m.mk -= delta / float64(m.n-1)
+	m.qk -= delta * (v - m.mk)
+	m.n--
+}
+
+func (m *slidingMoments) value() float64 {
+	return m.result(m)
+}
+
+// mean returns the mean of the values in the window
+func (m *slidingMoments) mean() float64 {
+	return m.mk
+}
+
+// variance returns the sample variance of the values in the window
+func (m *slidingMoments) variance() float64 {
+	var variance float64
+	if m.n > 1 {
+		variance = math.Max(m.qk, 0) / float64(m.n-1)
+	}
+	return variance
+}
+
+// slidingExtremum computes the minimum or maximum of the values in a window,
+// depending on less, via a monotonic queue of candidate values
+type slidingExtremum struct {
+	less           func(x, y float64) bool
+	queue          []indexedValue
+	added, removed int
+}
+
+// indexedValue is a value together with its position in the stream of
+// values added to a slidingExtremum
+type indexedValue struct {
+	index int
+	v     float64
+}
+
+func (e *slidingExtremum) add(v float64) {
+	// values which are not better than v can never become the extremum
+	for len(e.queue) > 0 && !e.less(e.queue[len(e.queue)-1].v, v) {
+		e.queue = e.queue[:len(e.queue)-1]
+	}
+	e.queue = append(e.queue, indexedValue{e.added, v})
+	e.added++
+}
+
+func (e *slidingExtremum) remove(v float64) {
+	if len(e.queue) > 0 && e.queue[0].index == e.removed {
+		e.queue = e.queue[1:]
+	}
+	e.removed++
+}
+
+func (e *slidingExtremum) value() float64 {
+	return e.queue[0].v
+}
+
+// slidingMedian computes the median of the values in a window
+type slidingMedian struct {
+	median *medData
+}
+
+func (s *slidingMedian) add(v float64) {
+	updateMedian(s.median, v)
+}
+
+func (s *slidingMedian) remove(v float64) {
+	removeMedian(s.median, v)
+}
+
+func (s *slidingMedian) value() float64 {
+	return s.median.val
+}
+
+// add appends the statistics for the provided row to a copy of the row.
+// Missing values are handled according to the missingSpec and the input
+// lines of the row are used for error reporting.
+func (r *rollingSpec) add(outRow []string, lines []int, m missingSpec) ([]string, error) {
+
+	extended := make([]string, len(outRow), len(outRow)+len(r.stats))
+	copy(extended, outRow)
+	for i, s := range r.stats {
+		if s.col >= len(outRow) {
+			return nil, fmt.Errorf("rolling statistic %s refers to column %d but the "+
+				"row from %s only has %d columns", r.names[i], s.col, m.rowPosition(lines),
+				len(outRow))
+		}
+		v, err := parseValue(outRow[s.col], m, lines, s.col)
+		if err != nil {
+			return nil, err
+		}
+		extended = append(extended, strconv.FormatFloat(s.action(v), 'g', -1, 64))
+	}
+	return extended, nil
+}
@@ -0,0 +1,480 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"container/heap"
+	"log"
+	"math"
+	"sort"
+)
+
+// NOTE: The statistics functions below propagate NaN values, i.e., their
+// result is NaN if any of the provided values is NaN. The same is true for
+// an empty list of values unless stated otherwise.
+
+// min returns the minumum value of an array of floats
+func min(fs []float64) float64 {
+	if len(fs) == 0 {
+		return math.NaN()
+	}
+	minVal := math.MaxFloat64
+	for _, f := range fs {
+		if math.IsNaN(f) {
+			return f
+		} else if f < minVal {
+			minVal = f
+		}
+	}
+	return minVal
+}
+
+// max returns the maximum value of an array of floats
+func max(fs []float64) float64 {
+	if len(fs) == 0 {
+		return math.NaN()
+	}
+	maxVal := -math.MaxFloat64
+	for _, f := range fs {
+		if math.IsNaN(f) {
+			return f
+		} else if f > maxVal {
+			maxVal = f
+		}
+	}
+	return maxVal
+}
+
+// hasNaN checks if any of the provided values is NaN
+func hasNaN(fs []float64) bool {
+	for _, f := range fs {
+		if math.IsNaN(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// mean computes the mean value of a list of float64 values
+func mean(items []float64) float64 {
+	var mean float64
+	for _, x := range items {
+		mean += x
+	}
+	return mean / float64(len(items))
+}
+
+// variance computes the variance of a list of float64 values
+func variance(items []float64) float64 {
+	var r runningStats
+	for _, d := range items {
+		r.update(d)
+	}
+	return r.variance()
+}
+
+// sum computes the sum of a list of float64 values. The sum of an empty list
+// is 0.
+func sum(items []float64) float64 {
+	var sum float64
+	for _, x := range items {
+		sum += x
+	}
+	return sum
+}
+
+// count returns the number of values in a list of float64 values including
+// NaN values
+func count(items []float64) float64 {
+	return float64(len(items))
+}
+
+// valueRange computes the difference between the maximum and minimum value
+// of a list of float64 values
+func valueRange(items []float64) float64 {
+	return max(items) - min(items)
+}
+
+// mode returns the most frequent value of a list of float64 values. If
+// several values are equally frequent the smallest one is returned.
+func mode(items []float64) float64 {
+	if len(items) == 0 || hasNaN(items) {
+		return math.NaN()
+	}
+
+	counts := make(map[float64]int)
+	for _, x := range items {
+		counts[x]++
+	}
+	var modeVal float64
+	var modeCount int
+	for x, c := range counts {
+		if c > modeCount || (c == modeCount && x < modeVal) {
+			modeVal = x
+			modeCount = c
+		}
+	}
+	return modeVal
+}
+
+// skewness computes the sample skewness g1 = m3/m2^(3/2) of a list of float64
+// values, where m2 and m3 are the second and third central moments
+func skewness(items []float64) float64 {
+	var r runningStats
+	for _, d := range items {
+		r.update(d)
+	}
+	return r.skewness()
+}
+
+// kurtosis computes the sample excess kurtosis g2 = m4/m2^2 - 3 of a list of
+// float64 values, where m2 and m4 are the second and fourth central moments
+func kurtosis(items []float64) float64 {
+	var r runningStats
+	for _, d := range items {
+		r.update(d)
+	}
+	return r.kurtosis()
+}
+
+// sem computes the standard error of the mean of a list of float64 values
+func sem(items []float64) float64 {
+	return math.Sqrt(variance(items) / float64(len(items)))
+}
+
+// mad computes the (unscaled) median absolute deviation of a list of float64
+// values
+func mad(items []float64) float64 {
+	med := median(items)
+	deviations := make([]float64, len(items))
+	for i, x := range items {
+		deviations[i] = math.Abs(x - med)
+	}
+	return median(deviations)
+}
+
+// geometricMean computes the geometric mean of a list of float64 values. It
+// is NaN if any of the values is negative.
+func geometricMean(items []float64) float64 {
+	var logSum float64
+	for _, x := range items {
+		logSum += math.Log(x)
+	}
+	return math.Exp(logSum / float64(len(items)))
+}
+
+// harmonicMean computes the harmonic mean of a list of float64 values
+func harmonicMean(items []float64) float64 {
+	var invSum float64
+	for _, x := range items {
+		invSum += 1 / x
+	}
+	return float64(len(items)) / invSum
+}
+
+// runningStats accumulates the sum, mean, variance, higher central moments,
+// minimum and maximum of a stream of values in a single pass
+type runningStats struct {
+	n        int
+	sum      float64
+	mk, qk   float64 // helper values for one pass variance computation
+	m3, m4   float64 // third and fourth central moment sums
+	min, max float64
+}
+
+// update adds the value d to the running statistics. The higher moments are
+// updated following Terriberry's extension of the one pass variance algorithm.
+func (r *runningStats) update(d float64) {
+	// once a NaN is seen the minimum and maximum remain NaN
+	if r.n == 0 || d < r.min || math.IsNaN(d) {
+		r.min = d
+	}
+	if r.n == 0 || d > r.max || math.IsNaN(d) {
+		r.max = d
+	}
+	r.n++
+	r.sum += d
+	k := float64(r.n)
+	delta := d - r.mk
+	deltaK := delta / k
+	deltaK2 := deltaK * deltaK
+	term := delta * deltaK * (k - 1)
+	r.m4 += term*deltaK2*(k*k-3*k+3) + 6*deltaK2*r.qk - 4*deltaK*r.m3
+	r.m3 += term*deltaK*(k-2) - 3*deltaK*r.qk
+	r.qk += term
+	r.mk += deltaK
+}
+
+// mean returns the mean of all values seen so far. It is computed from the
+// plain sum like mean so that infinite values yield an infinite mean.
+func (r *runningStats) mean() float64 {
+	if r.n == 0 {
+		return math.NaN()
+	}
+	return r.sum / float64(r.n)
+}
+
+// minimum returns the smallest of all values seen so far
+func (r *runningStats) minimum() float64 {
+	if r.n == 0 {
+		return math.NaN()
+	}
+	return r.min
+}
+
+// maximum returns the largest of all values seen so far
+func (r *runningStats) maximum() float64 {
+	if r.n == 0 {
+		return math.NaN()
+	}
+	return r.max
+}
+
+// variance returns the variance of all values seen so far
+func (r *runningStats) variance() float64 {
+	var variance float64
+	if r.n > 1 {
+		variance = r.qk / float64(r.n-1)
+	}
+	return variance
+}
+
+// skewness returns the sample skewness of all values seen so far. It is NaN
+// if fewer than two distinct values were seen.
+func (r *runningStats) skewness() float64 {
+	if r.qk == 0 {
+		return math.NaN()
+	}
+	return math.Sqrt(float64(r.n)) * r.m3 / math.Pow(r.qk, 1.5)
+}
+
+// kurtosis returns the sample excess kurtosis of all values seen so far. It
+// is NaN if fewer than two distinct values were seen.
+func (r *runningStats) kurtosis() float64 {
+	if r.qk == 0 {
+		return math.NaN()
+	}
+	return float64(r.n)*r.m4/(r.qk*r.qk) - 3
+}
+
+// median computes the median of the provided
+func median(fs []float64) float64 {
+	if len(fs) == 0 || hasNaN(fs) {
+		return math.NaN()
+	}
+	m := newMedData()
+	for _, f := range fs {
+		updateMedian(m, f)
+	}
+	return m.val
+}
+
+// quantile computes the q-th quantile (0 <= q <= 1) of the provided values.
+// It interpolates linearly between the closest ranks, i.e., it uses the
+// definition 7 of Hyndman and Fan which is the default of R and NumPy.
+func quantile(fs []float64, q float64) float64 {
+	if len(fs) == 0 || hasNaN(fs) {
+		return math.NaN()
+	}
+
+	sorted := make([]float64, len(fs))
+	copy(sorted, fs)
+	sort.Float64s(sorted)
+
+	h := q * float64(len(sorted)-1)
+	lo := math.Floor(h)
+	i := int(lo)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (h-lo)*(sorted[i+1]-sorted[i])
+}
+
+// iqr computes the interquartile range of the provided values
+func iqr(fs []float64) float64 {
+	return quantile(fs, 0.75) - quantile(fs, 0.25)
+}
+
+// trimmedMean computes the mean of the provided values after discarding the
+// fraction frac of the smallest and of the largest values
+func trimmedMean(fs []float64, frac float64) float64 {
+	if len(fs) == 0 || hasNaN(fs) {
+		return math.NaN()
+	}
+
+	sorted := make([]float64, len(fs))
+	copy(sorted, fs)
+	sort.Float64s(sorted)
+
+	k := int(frac * float64(len(sorted)))
+	return mean(sorted[k : len(sorted)-k])
+}
+
+// medData holds the data structures needed to compute a running median.
+// Currently, the running median is implemented via a min and max heap data
+// structure and thus requires storage on the order of the data set size.
+// Removed values are deleted lazily once they reach the top of their heap,
+// until then they are counted in removed and excluded from the heap sizes.
+type medData struct {
+	smaller, larger   floatHeap // smaller holds the negated values
+	nSmaller, nLarger int       // number of values in each heap not removed
+	removed           map[float64]int
+	val               float64
+}
+
+// newMedData initializes the data structure for computing the running median
+func newMedData() *medData {
+	var m medData
+	heap.Init(&m.smaller)
+	heap.Init(&m.larger)
+	return &m
+}
+
+// updateMedian updates the running median using two heaps the each keep
+// track of elements smaller and larger than the current median.
+func updateMedian(m *medData, v float64) *medData {
+	if m.nSmaller == 0 || v <= -m.smaller[0] {
+		heap.Push(&m.smaller, -v)
+		m.nSmaller++
+	} else {
+		heap.Push(&m.larger, v)
+		m.nLarger++
+	}
+	rebalanceMedian(m)
+	return m
+}
+
+// removeMedian removes a previously added value from the running median,
+// e.g., once it drops out of a sliding window. The value stays in its heap
+// until it reaches the top so that the removal takes O(log n) amortized.
+func removeMedian(m *medData, v float64) *medData {
+	if m.nSmaller == 0 && m.nLarger == 0 {
+		return m
+	}
+	if m.removed == nil {
+		m.removed = make(map[float64]int)
+	}
+	m.removed[v]++
+	if m.nSmaller > 0 && v <= -m.smaller[0] {
+		m.nSmaller--
+	} else {
+		m.nLarger--
+	}
+	rebalanceMedian(m)
+	return m
+}
+
+// prune pops removed values off the top of h. The values in h are negated
+// if negate is set.
+func (m *medData) prune(h *floatHeap, negate bool) {
+	for len(*h) > 0 && len(m.removed) > 0 {
+		v := (*h)[0]
+		if negate {
+			v = -v
+		}
+		n := m.removed[v]
+		if n == 0 {
+			return
+		}
+		if n == 1 {
+			delete(m.removed, v)
+		} else {
+			m.removed[v] = n - 1
+		}
+		heap.Pop(h)
+	}
+}
+
+// compact drops all removed values from the heaps once they make up the
+// majority of stored values so that storage stays proportional to the
+// number of values in the running median
+func (m *medData) compact() {
+	if len(m.smaller)+len(m.larger) <= 2*(m.nSmaller+m.nLarger)+16 {
+		return
+	}
+	for _, h := range []*floatHeap{&m.smaller, &m.larger} {
+		negate := h == &m.smaller
+		kept := (*h)[:0]
+		for _, x := range *h {
+			v := x
+			if negate {
+				v = -v
+			}
+			if n := m.removed[v]; n > 0 {
+				if n == 1 {
+					delete(m.removed, v)
+				} else {
+					m.removed[v] = n - 1
+				}
+				continue
+			}
+			kept = append(kept, x)
+		}
+		*h = kept
+		heap.Init(h)
+	}
+}
+
+// rebalanceMedian fixes up the heaps after an insertion or removal and
+// computes the new median. The smaller heap holds as many values as the
+// larger one or one more. The median of no values is NaN.
+func rebalanceMedian(m *medData) {
+	m.compact()
+	m.prune(&m.smaller, true)
+	m.prune(&m.larger, false)
+	if m.nSmaller > m.nLarger+1 {
+		heap.Push(&m.larger, -heap.Pop(&m.smaller).(float64))
+		m.nSmaller--
+		m.nLarger++
+		m.prune(&m.smaller, true)
+	} else if m.nLarger > m.nSmaller {
+		heap.Push(&m.smaller, -heap.Pop(&m.larger).(float64))
+		m.nLarger--
+		m.nSmaller++
+		m.prune(&m.larger, false)
+	}
+
+	// compute new median
+	if m.nSmaller == 0 {
+		m.val = math.NaN()
+	} else if m.nSmaller == m.nLarger {
+		m.val = 0.5 * (m.larger[0] - m.smaller[0])
+	} else {
+		m.val = -m.smaller[0]
+	}
+
+	if m.nSmaller-m.nLarger > 1 || m.nLarger > m.nSmaller {
+		log.Panic("median heaps are unbalanced")
+	}
+}
+
+// floatHeap is a min-heap of float64
+type floatHeap []float64
+
+// implement heap interface for floatHeap
+func (f floatHeap) Len() int {
+	return len(f)
+}
+
+func (f floatHeap) Less(i, j int) bool {
+	return f[i] < f[j]
+}
+
+func (f floatHeap) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+// Push is part of heap interface
+func (f *floatHeap) Push(x interface{}) {
+	*f = append(*f, x.(float64))
+}
+
+// Pop is part of heap interface
+func (f *floatHeap) Pop() interface{} {
+	old := *f
+	n := len(old)
+	x := old[n-1]
+	*f = old[0 : n-1]
+	return x
+}
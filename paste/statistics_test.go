@@ -0,0 +1,201 @@
+// unit tests for the statistics functions of pst
+package paste
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testData is the data set used for testing the statistics functions. The
+// expected values were computed with Python's statistics module.
+var testData = []float64{2, 4, 4, 4, 5, 5, 7, 9, 1.5}
+
+// floatsClose is a helper function for comparing floats up to a relative
+// tolerance
+func floatsClose(x, y float64) bool {
+	return math.Abs(x-y) <= 1e-12*math.Max(1, math.Abs(y))
+}
+
+// checkAction is a helper function comparing the result of a compute action
+// on testData to its expected value
+func checkAction(t *testing.T, name string, act ComputeAction, expected float64) {
+	if r := act(testData); !floatsClose(r, expected) {
+		t.Errorf("expected %v and computed %v %s don't match", expected, r, name)
+	}
+}
+
+// Test_basicStats tests the mean, variance, minimum, maximum and median
+func Test_basicStats(t *testing.T) {
+	checkAction(t, "mean", mean, 4.611111111111111)
+	checkAction(t, "variance", variance, 5.361111111111111)
+	checkAction(t, "min", min, 1.5)
+	checkAction(t, "max", max, 9)
+	checkAction(t, "median", median, 4)
+}
+
+// Test_sum tests the sum
+func Test_sum(t *testing.T) {
+	checkAction(t, "sum", sum, 41.5)
+}
+
+// Test_count tests the number of values
+func Test_count(t *testing.T) {
+	checkAction(t, "count", count, 9)
+}
+
+// Test_valueRange tests the range
+func Test_valueRange(t *testing.T) {
+	checkAction(t, "range", valueRange, 7.5)
+}
+
+// Test_mode tests the mode including ties
+func Test_mode(t *testing.T) {
+	checkAction(t, "mode", mode, 4)
+	if r := mode([]float64{3, 1, 3, 1, 2}); r != 1 {
+		t.Errorf("expected smallest of the most frequent values 1 but got %v", r)
+	}
+	if r := mode(nil); !math.IsNaN(r) {
+		t.Errorf("expected NaN as mode of empty data but got %v", r)
+	}
+}
+
+// Test_skewness tests the sample skewness and its behavior for constant data
+func Test_skewness(t *testing.T) {
+	checkAction(t, "skewness", skewness, 0.5307437977205012)
+	if r := skewness([]float64{1, 1, 1}); !math.IsNaN(r) {
+		t.Errorf("expected NaN as skewness of constant data but got %v", r)
+	}
+}
+
+// Test_kurtosis tests the sample excess kurtosis and its behavior for
+// constant data
+func Test_kurtosis(t *testing.T) {
+	checkAction(t, "kurtosis", kurtosis, -0.33718690434642484)
+	if r := kurtosis([]float64{1, 1, 1}); !math.IsNaN(r) {
+		t.Errorf("expected NaN as kurtosis of constant data but got %v", r)
+	}
+}
+
+// Test_momentsStability checks that the one pass moments are not affected by
+// a large offset of the data
+func Test_momentsStability(t *testing.T) {
+	shifted := make([]float64, len(testData))
+	for i, x := range testData {
+		shifted[i] = x + 1e9
+	}
+	if r := skewness(shifted); math.Abs(r-0.5307437977205012) > 1e-6 {
+		t.Errorf("skewness of shifted data %v differs from %v", r, 0.5307437977205012)
+	}
+	if r := kurtosis(shifted); math.Abs(r+0.33718690434642484) > 1e-6 {
+		t.Errorf("kurtosis of shifted data %v differs from %v", r, -0.33718690434642484)
+	}
+}
+
+// Test_sem tests the standard error of the mean
+func Test_sem(t *testing.T) {
+	checkAction(t, "sem", sem, 0.7718024438583225)
+}
+
+// Test_mad tests the median absolute deviation
+func Test_mad(t *testing.T) {
+	checkAction(t, "mad", mad, 1)
+}
+
+// Test_geometricMean tests the geometric mean
+func Test_geometricMean(t *testing.T) {
+	checkAction(t, "geometric mean", geometricMean, 4.06399843346462)
+	if r := geometricMean([]float64{1, -1}); !math.IsNaN(r) {
+		t.Errorf("expected NaN as geometric mean of negative data but got %v", r)
+	}
+}
+
+// Test_harmonicMean tests the harmonic mean
+func Test_harmonicMean(t *testing.T) {
+	checkAction(t, "harmonic mean", harmonicMean, 3.5010805804260574)
+}
+
+// Test_statsActions checks that the statistics are available by name as
+// compute actions
+func Test_statsActions(t *testing.T) {
+	names := "sum, count, range, mode, skew, kurt, sem, mad, gmean, hmean"
+	expectedResult := []float64{41.5, 9, 7.5, 4, 0.5307437977205012, -0.33718690434642484,
+		0.7718024438583225, 1, 4.06399843346462, 3.5010805804260574}
+	actions, err := getComputeSpecs(names)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, a := range actions {
+		if r := a(testData); !floatsClose(r, expectedResult[i]) {
+			t.Errorf("expected %v and computed %v results of action #%d don't match",
+				expectedResult[i], r, i)
+		}
+	}
+}
+
+// Test_nanPropagation checks that NaN values propagate to the results of all
+// statistics functions
+func Test_nanPropagation(t *testing.T) {
+	data := []float64{1, math.NaN(), 3}
+	actions := []ComputeAction{mean, variance, min, max, median, sum, valueRange, mode,
+		skewness, kurtosis, sem, mad, geometricMean, harmonicMean, iqr}
+	for i, a := range actions {
+		if r := a(data); !math.IsNaN(r) {
+			t.Errorf("expected NaN but computed %v for action #%d", r, i)
+		}
+	}
+}
+
+// Test_removeMedian checks that the running median stays correct while
+// values are removed again
+func Test_removeMedian(t *testing.T) {
+	m := newMedData()
+	for _, v := range testData {
+		updateMedian(m, v)
+	}
+	for i, v := range testData[:len(testData)-1] {
+		removeMedian(m, v)
+		if expected := median(testData[i+1:]); m.val != expected {
+			t.Errorf("expected %v and computed %v median after removing %d values "+
+				"don't match", expected, m.val, i+1)
+		}
+	}
+	removeMedian(m, testData[len(testData)-1])
+	if !math.IsNaN(m.val) {
+		t.Errorf("expected NaN as median of no values but got %v", m.val)
+	}
+}
+
+// Test_slidingMedian checks the running median of a sliding window over
+// values with many duplicates and that removed values do not accumulate
+func Test_slidingMedian(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, window := range []int{1, 2, 5, 50} {
+		values := make([]float64, 5000)
+		for i := range values {
+			// a trend makes removed values end up deep inside the heaps
+			values[i] = float64(rng.Intn(10) + i/10)
+		}
+		m := newMedData()
+		for i, v := range values {
+			updateMedian(m, v)
+			if i >= window {
+				removeMedian(m, values[i-window])
+			}
+			lo := i + 1 - window
+			if lo < 0 {
+				lo = 0
+			}
+			if expected := quantile(values[lo:i+1], 0.5); m.val != expected {
+				t.Errorf("expected %v and computed %v median of window %d at value %d "+
+					"don't match", expected, m.val, window, i)
+				return
+			}
+		}
+		if n := len(m.smaller) + len(m.larger); n > 2*window+16 {
+			t.Errorf("expected at most %d and got %d stored values for window %d",
+				2*window+16, n, window)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"fmt"
+	"math"
+)
+
+// columnStats accumulates the statistics of a single output column across
+// all rows
+type columnStats struct {
+	stats  runningStats
+	median *medData  // only tracked if a median was requested
+	values []float64 // only tracked if an action requires all values
+	hasNaN bool      // the column contains NaN values
+}
+
+// columnAction computes a statistic from the accumulated column statistics
+type columnAction func(*columnStats) float64
+
+// columnSummary computes column wise statistics across all output rows and
+// prints one summary row per requested action once all rows have been seen.
+// Each summary row starts with the name of its action.
+type columnSummary struct {
+	actions    []columnAction
+	names      []string       // name of each action
+	formats    []numberFormat // number format for each action
+	missing    missingSpec    // handling of missing values
+	withMedian bool
+	withValues bool
+	columns    []*columnStats
+}
+
+// getColumnSummary parses the comma separated list of compute actions for
+// column wise statistics
+func getColumnSummary(actions string) (*columnSummary, error) {
+
+	var s columnSummary
+	for _, val := range expandActions(actions) {
+		var act columnAction
+		switch val {
+		case "mean":
+			act = func(c *columnStats) float64 { return c.stats.mean() }
+		case "var":
+			act = func(c *columnStats) float64 { return c.stats.variance() }
+		case "std":
+			act = func(c *columnStats) float64 { return math.Sqrt(c.stats.variance()) }
+		case "max":
+			act = func(c *columnStats) float64 { return c.stats.maximum() }
+		case "min":
+			act = func(c *columnStats) float64 { return c.stats.minimum() }
+		case "median":
+			act = func(c *columnStats) float64 {
+				if c.hasNaN {
+					return math.NaN()
+				}
+				return c.median.val
+			}
+			s.withMedian = true
+		case "sum":
+			act = func(c *columnStats) float64 { return c.stats.sum }
+		case "count":
+			act = func(c *columnStats) float64 { return float64(c.stats.n) }
+		case "range":
+			act = func(c *columnStats) float64 { return c.stats.maximum() - c.stats.minimum() }
+		case "skew":
+			act = func(c *columnStats) float64 { return c.stats.skewness() }
+		case "kurt":
+			act = func(c *columnStats) float64 { return c.stats.kurtosis() }
+		case "sem":
+			act = func(c *columnStats) float64 {
+				return math.Sqrt(c.stats.variance() / float64(c.stats.n))
+			}
+		default:
+			// all other actions are computed from the buffered column values
+			rowActions, err := parseComputeSpec(val)
+			if err != nil {
+				return nil, err
+			}
+			rowAction := rowActions[0]
+			act = func(c *columnStats) float64 { return rowAction(c.values) }
+			s.withValues = true
+		}
+		s.actions = append(s.actions, act)
+		s.names = append(s.names, val)
+	}
+	return &s, nil
+}
+
+// add updates the column statistics with the values of the provided row.
+// In skip mode missing values and NaN are ignored. The input lines of the row
+// are used for error reporting.
+func (s *columnSummary) add(outRow []string, lines []int) error {
+
+	items, err := splitIntoFloats(outRow, s.missing, lines)
+	if err != nil {
+		return err
+	}
+
+	if s.columns == nil {
+		s.columns = make([]*columnStats, len(items))
+		for i := range s.columns {
+			s.columns[i] = &columnStats{}
+			if s.withMedian {
+				s.columns[i].median = newMedData()
+			}
+		}
+	} else if len(items) != len(s.columns) {
+		return fmt.Errorf("expected %d columns but row has %d", len(s.columns), len(items))
+	}
+
+	for i, v := range items {
+		c := s.columns[i]
+		if math.IsNaN(v) {
+			if s.missing.policy == skipMissing {
+				continue
+			}
+			c.hasNaN = true
+		}
+		c.stats.update(v)
+		if c.median != nil && !c.hasNaN {
+			updateMedian(c.median, v)
+		}
+		if s.withValues {
+			c.values = append(c.values, v)
+		}
+	}
+	return nil
+}
+
+// print prints one row per compute action containing the action's name and
+// its result for each column. Nothing is printed if no rows were added.
+func (s *columnSummary) print(output rowWriter) error {
+
+	if s.columns == nil {
+		return nil
+	}
+
+	outRow := make([]string, len(s.columns)+1)
+	for j, a := range s.actions {
+		outRow[0] = s.names[j]
+		for i, c := range s.columns {
+			outRow[i+1] = s.formats[j](a(c))
+		}
+		if err := output.writeRow(outRow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
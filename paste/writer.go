@@ -0,0 +1,284 @@
+// Copyright 2014 Markus Dittrich
+// Licensed under BSD license, see LICENSE file for details
+
+package paste
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// outputFormat selects how output rows are written
+type outputFormat int
+
+const (
+	plainOutput    outputFormat = iota // columns joined by the output separator
+	csvOutput                          // RFC 4180 csv
+	tsvOutput                          // tab separated values quoted like csv
+	jsonOutput                         // JSON Lines, one object per row
+	markdownOutput                     // Markdown table
+	tableOutput                        // plain text table with aligned columns
+)
+
+// parseOutputFormat converts the name of an output format into an
+// outputFormat
+func parseOutputFormat(name string) (outputFormat, error) {
+	switch strings.TrimSpace(name) {
+	case "", "plain":
+		return plainOutput, nil
+	case "csv":
+		return csvOutput, nil
+	case "tsv":
+		return tsvOutput, nil
+	case "jsonl":
+		return jsonOutput, nil
+	case "markdown":
+		return markdownOutput, nil
+	case "table":
+		return tableOutput, nil
+	}
+	return plainOutput, fmt.Errorf("unknown output format %s, expected one of plain, "+
+		"csv, tsv, jsonl, markdown, or table", name)
+}
+
+// rowWriter writes the output rows in a particular output format. The
+// header, if any, is written before the first row. flush has to be called
+// once all rows are written.
+type rowWriter interface {
+	writeHeader(header []string) error
+	writeRow(row []string) error
+	flush() error
+}
+
+// newRowWriter creates a rowWriter for the requested output format writing
+// to w. The separator is only used by the plain output format.
+func newRowWriter(format outputFormat, w io.Writer, sep string) rowWriter {
+
+	output := bufio.NewWriter(w)
+	switch format {
+	case csvOutput, tsvOutput:
+		c := csv.NewWriter(output)
+		if format == tsvOutput {
+			c.Comma = '\t'
+		}
+		return &csvWriter{c, output}
+	case jsonOutput:
+		return &jsonWriter{output: output}
+	case markdownOutput:
+		return &markdownWriter{output: output}
+	case tableOutput:
+		return &tableWriter{tabwriter.NewWriter(output, 0, 0, 2, ' ', 0), output}
+	}
+	return &plainWriter{output, sep}
+}
+
+// columnNames returns header names for n columns of the form "cN"
+func columnNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("c%d", i)
+	}
+	return names
+}
+
+// plainWriter writes rows with their columns joined by a separator
+type plainWriter struct {
+	output *bufio.Writer
+	sep    string
+}
+
+func (p *plainWriter) writeHeader(header []string) error {
+	return p.writeRow(header)
+}
+
+func (p *plainWriter) writeRow(row []string) error {
+	for i, item := range row {
+		if i > 0 {
+			p.output.WriteString(p.sep)
+		}
+		p.output.WriteString(item)
+	}
+	return p.output.WriteByte('\n')
+}
+
+func (p *plainWriter) flush() error {
+	return p.output.Flush()
+}
+
+// csvWriter writes rows as csv or tsv records. Fields containing the
+// separator, quotes or newlines are quoted.
+type csvWriter struct {
+	records *csv.Writer
+	output  *bufio.Writer
+}
+
+func (c *csvWriter) writeHeader(header []string) error {
+	return c.writeRow(header)
+}
+
+func (c *csvWriter) writeRow(row []string) error {
+	return c.records.Write(row)
+}
+
+func (c *csvWriter) flush() error {
+	c.records.Flush()
+	if err := c.records.Error(); err != nil {
+		return err
+	}
+	return c.output.Flush()
+}
+
+// jsonWriter writes each row as a JSON object on a line of its own. The
+// header names are used as keys, columns without a header name are called
+// "cN". Repeated keys are made unique by appending "_2", "_3", and so on.
+// Values which are valid JSON numbers are written as is, all others as
+// strings.
+type jsonWriter struct {
+	output *bufio.Writer
+	keys   []string
+	seen   map[string]bool
+}
+
+// jsonNumberRegexp matches the JSON number syntax
+var jsonNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+func (j *jsonWriter) writeHeader(header []string) error {
+	j.keys, j.seen = nil, nil
+	for _, name := range header {
+		j.addKey(name)
+	}
+	return nil
+}
+
+// addKey appends name to the keys, made unique if it is already in use
+func (j *jsonWriter) addKey(name string) {
+	if j.seen == nil {
+		j.seen = make(map[string]bool)
+	}
+	key := name
+	for n := 2; j.seen[key]; n++ {
+		key = fmt.Sprintf("%s_%d", name, n)
+	}
+	j.seen[key] = true
+	j.keys = append(j.keys, key)
+}
+
+func (j *jsonWriter) writeRow(row []string) error {
+
+	for len(j.keys) < len(row) {
+		j.addKey(fmt.Sprintf("c%d", len(j.keys)))
+	}
+	j.output.WriteByte('{')
+	for i, item := range row {
+		if i > 0 {
+			j.output.WriteByte(',')
+		}
+		if err := writeJSONString(j.output, j.keys[i]); err != nil {
+			return err
+		}
+		j.output.WriteByte(':')
+
+		if v := strings.TrimSpace(item); jsonNumberRegexp.MatchString(v) {
+			j.output.WriteString(v)
+		} else if err := writeJSONString(j.output, item); err != nil {
+			return err
+		}
+	}
+	_, err := j.output.WriteString("}\n")
+	return err
+}
+
+func (j *jsonWriter) flush() error {
+	return j.output.Flush()
+}
+
+// writeJSONString writes s as a quoted JSON string
+func writeJSONString(output *bufio.Writer, s string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(b)
+	return err
+}
+
+// markdownWriter writes the rows as a Markdown table. Since Markdown tables
+// require a header, columns are called "cN" if no header is provided.
+type markdownWriter struct {
+	output     *bufio.Writer
+	withHeader bool
+}
+
+func (m *markdownWriter) writeHeader(header []string) error {
+	m.withHeader = true
+	if err := m.writeLine(header); err != nil {
+		return err
+	}
+	rule := make([]string, len(header))
+	for i := range rule {
+		rule[i] = "---"
+	}
+	return m.writeLine(rule)
+}
+
+func (m *markdownWriter) writeRow(row []string) error {
+	if !m.withHeader {
+		if err := m.writeHeader(columnNames(len(row))); err != nil {
+			return err
+		}
+	}
+	return m.writeLine(row)
+}
+
+// markdownEscaper escapes characters which would break a Markdown table
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+// writeLine writes the cells as a single line of a Markdown table
+func (m *markdownWriter) writeLine(cells []string) error {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		escaped[i] = markdownEscaper.Replace(c)
+	}
+	_, err := fmt.Fprintf(m.output, "| %s |\n", strings.Join(escaped, " | "))
+	return err
+}
+
+func (m *markdownWriter) flush() error {
+	return m.output.Flush()
+}
+
+// tableWriter writes the rows as a plain text table with aligned columns.
+// NOTE: Aligning the columns requires all rows to be buffered until flush.
+type tableWriter struct {
+	table  *tabwriter.Writer
+	output *bufio.Writer
+}
+
+func (t *tableWriter) writeHeader(header []string) error {
+	return t.writeRow(header)
+}
+
+// tableEscaper replaces the characters used by tabwriter to delimit cells
+var tableEscaper = strings.NewReplacer("\t", " ", "\n", " ")
+
+func (t *tableWriter) writeRow(row []string) error {
+	cells := make([]string, len(row))
+	for i, item := range row {
+		cells[i] = tableEscaper.Replace(item)
+	}
+	_, err := fmt.Fprintf(t.table, "%s\n", strings.Join(cells, "\t"))
+	return err
+}
+
+func (t *tableWriter) flush() error {
+	if err := t.table.Flush(); err != nil {
+		return err
+	}
+	return t.output.Flush()
+}
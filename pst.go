@@ -3,95 +3,199 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"runtime"
-	"sort"
-	"strconv"
 	"strings"
-	"sync"
-	"unicode"
+
+	"github.com/haskelladdict/pst/paste"
 )
 
 const version = "0.1"
 
-// Spec describes what to parse and how to assemble the output
-type Spec struct {
-	input     string
-	output    string
-	inputSep  string
-	outputSep string
-	compute   string
-	rows      string
-}
-
 // command line switches
 var (
 	numThreads int
-	spec       Spec
+	spec       paste.Spec
 	showHelp   bool
 )
 
-// parseSpec describes for each input files which columns to parse
-type parseSpec []int
-
-// computeAction describes a computation to performed on row/column data
-type computeAction func([]float64) float64
-
-// computeSpec describes a list of computeActions to be performed on row/column data
-type computeSpec []computeAction
-
 func init() {
-	flag.StringVar(&spec.input, "i", "",
+	flag.StringVar(&spec.Input, "i", "",
 		`specify the input columns to extract. This flag is optional.
      The spec format is "<column list file1>|<column list file2>|..."
      where each column specifier is of the form col_i,col_j,col_k-col_n, ....
      If the number of specifiers is less than the number of files, the last
      specifier i will be applied to files i through N, where N is the total
      number of files provided. If this flag is not provided all input columns
-     will be extracted.`)
-	flag.StringVar(&spec.compute, "c", "",
+     will be extracted. Ranges can be open ended, strided, and use negative
+     indices counting from the end, e.g. "2-", "-1--1", or "0-:2".`)
+	flag.StringVar(&spec.Compute, "c", "",
 		`compute statistics across column values in each output row.
      Please note that each value in the output has to be convertible into a float
      for this to work. The computed statistics are determined by a comma separated
      list of actions. The result of each action is printed as a separate column value.
      Currently supported compute actions are:
-         - mean  : compute row mean
-         - std   : compute row standard deviation
-         - var   : compute row variance
-         - median: compute row median
-         - max   : compute maximum value of row
-         - min   : compute minimum value of row
+`+actionHelp()+`
+     Quantiles interpolate linearly between the closest ranks (definition 7
+     of Hyndman and Fan, the default of R and NumPy).
      Thus, "mean, std, median" will result in three columns per row, with the
      mean, standard deviation and median of the raw column values.`)
-	flag.StringVar(&spec.inputSep, "s", "",
-		`column separator for input files. The default separator is whitespace.`)
-	flag.StringVar(&spec.outputSep, "t", " ",
+	flag.BoolVar(&spec.AppendCols, "append", false,
+		`print the selected output columns followed by the results of the compute
+     actions requested via -c instead of only the computed values.`)
+	flag.BoolVar(&spec.Vertical, "vertical", false,
+		`compute the statistics requested via -c column wise across all rows
+     instead of across the values of each row. One summary row is printed per
+     compute action, containing the action's name followed by its result for
+     each output column. With -header the name column is called "action".`)
+	flag.StringVar(&spec.Missing, "missing", "fail",
+		`policy for missing and non-numeric values such as "NA", "-" or empty
+     fields encountered by compute actions and numeric comparisons in
+     expressions. Supported are
+         - fail  : stop with an error naming the file, line and column
+         - skip  : ignore missing values as well as NaN, e.g. mean acts
+                   like nanmean
+         - nan   : treat missing values as NaN which propagates to the results
+         - <num> : replace missing values with the number <num>, e.g. 0`)
+	flag.StringVar(&spec.Format, "f", "",
+		`number format for computed values. Accepts a printf floating point verb
+     such as %g, %.6e, or %.3f, or "shortest" for the shortest representation
+     which round trips to the same value. Different formats can be chosen per
+     compute action via a comma separated list such as "%.3f,std=%.2e",
+     where the plain entry applies to all other actions. The default is %15.15f.`)
+	flag.StringVar(&spec.InputSep, "s", "",
+		`column separator for input files. The default separator is whitespace.
+     In csv mode the separator has to be a single character and defaults to ','.`)
+	flag.IntVar(&spec.MaxLine, "maxline", 0,
+		`maximum length of input lines in bytes. Longer lines stop processing with
+     an error naming the file and line. By default lines of any length are
+     accepted. This does not apply to csv files.`)
+	flag.BoolVar(&spec.CSV, "csv", false,
+		`parse input files as RFC 4180 csv. Fields may be quoted and quoted fields
+     can contain separators, escaped quotes ("") and newlines. Column
+     specifiers then refer to csv fields and row specifiers to csv records.`)
+	flag.StringVar(&spec.OutputSep, "t", " ",
 		`column separator for output files. The default separator is a single space.`)
+	flag.StringVar(&spec.OutFormat, "outformat", "plain",
+		`output format. Supported are
+         - plain   : columns separated by the -t separator
+         - csv     : RFC 4180 csv with quoted fields where required
+         - tsv     : tab separated values quoted like csv
+         - jsonl   : JSON Lines with one object per row. The header names
+                     are used as keys, otherwise "cN", with "_2", "_3", ...
+                     appended to repeated keys. Values in JSON number
+                     syntax are written as is, all others as strings.
+         - markdown: Markdown table with "cN" column names unless -header
+         - table   : plain text table with aligned columns. The complete
+                     output is held in memory until it is printed.`)
 	flag.BoolVar(&showHelp, "h", false, "show basic usage info")
-	flag.StringVar(&spec.output, "o", "",
+	flag.BoolVar(&spec.Header, "header", false,
+		`treat the first line of each input file as a header. Columns in the
+     input and output specs can then be selected by name in addition to
+     their index, e.g. -i "time,temp|pressure" -o "temp,pressure,time".
+     Row specifiers refer to the data rows following the header.
+     The output starts with a combined header line following the output spec.
+     Names occurring in several files are prefixed with their file name as
+     in "file:name". With -c the header lists the compute actions instead.`)
+	flag.StringVar(&spec.Output, "o", "",
 		`specify the order in which to print the output columns. This flag is optional.
      The spec format is "i,j,k-l,m,..", where 0 < i,j,k,l,m, ... < numCol, and
      numCol is the total number of columns extracted from the input files.
      Columns can be specified multiple times and ranges are accepted. If this
      option is not provided the columns are pasted in the order in which they
-     are extracted.`)
-	flag.StringVar(&spec.rows, "r", "",
+     are extracted. Ranges follow the same syntax as for -i. Columns can also
+     be qualified by their zero based input file as "file:columns", e.g.
+     "2:1,1:0,0:0-2" selects column 1 of file 2, column 0 of file 1, and
+     columns 0 through 2 of file 0. These refer to the columns of the file
+     itself and have to be extracted via -i.`)
+	flag.StringVar(&spec.Exprs, "e", "",
+		`compute the output columns from a comma separated list of expressions
+     instead of selecting them via -o, e.g. "c0, c3/c1, log(c2)*1000, c4-c5".
+     cN refers to column N of the columns extracted from all input files.
+     Expressions support numbers, "strings", the arithmetic operators
+     + - * / % ^, comparisons == != < <= > >=, the logical operators && || !,
+     conditionals "cond ? a : b" and if(cond, a, b), and the functions abs,
+     sqrt, exp, log, log2, log10, sin, cos, tan, floor, ceil, round, pow, and
+     min, max, mean, sum of one or more arguments. Comparisons with a number
+     are numeric, non-numeric values are handled according to -missing and
+     compare false if skipped or NaN. Other comparisons are numeric if both
+     sides are numbers and by string otherwise. Computed numbers are printed
+     in their shortest representation, plain columns as is.`)
+	flag.StringVar(&spec.Filter, "filter", "",
+		`only process rows for which the provided expression is true, e.g.
+     'c2 > 0.5 && c0 != "ERR"'. The expression uses the same syntax as -e
+     and cN refers to column N of the assembled row before -o or -e are
+     applied. Filtered rows are excluded from all computations.`)
+	flag.StringVar(&spec.Rolling, "rolling", "",
+		`append windowed and running statistics of output columns as new columns,
+     e.g. "mean(c1, 5), median(c1, 7), cumsum(c2)". cN refers to column N of
+     the output row. The windowed statistics mean, std, var, min, max, and
+     median take the window size in rows as second argument and are NaN until
+     the window is full. cumsum and runmean are computed over all rows seen
+     so far. Missing values are handled according to -missing and the results
+     are printed in their shortest representation. The new columns take part
+     in compute actions like all other output columns.`)
+	flag.StringVar(&spec.Rows, "r", "",
 		`specify which rows to process and output. This flag is optional.
      If not specified all rows will be output. Rows can be specified by a comma
      separated list of row IDs or row ID ranges. E.g., "1,2,4-8,22" will process
-     rows 1, 2, 4, 5, 7, 22.`)
-	flag.IntVar(&numThreads, "n", 1, "number of threads (default: 1)")
+     rows 1, 2, 4, 5, 6, 7, 8, 22. Ranges follow the same syntax as for -i,
+     e.g. "100-" processes row 100 to the end, "-3-" the last three rows, and
+     "0-1000:10" every 10th row.`)
+	flag.StringVar(&spec.Keys, "k", "",
+		`join the input files on a key column instead of pasting them row by row.
+     The spec format is "<key column file1>|<key column file2>|..." with one
+     column per file, which is padded like the input spec. Requires -i.
+     Files are joined as streams as long as they are sorted by key, keys
+     being compared numerically if possible. From the first key out of order
+     on the remaining input is loaded into memory and joined via hashing,
+     without matching the rows joined before.`)
+	flag.StringVar(&spec.Join, "join", "inner",
+		`type of key join requested via -k. Supported types are
+         - inner : keep keys present in all files
+         - left  : keep keys present in the first file
+         - outer : keep keys present in any file
+     Columns of files without a matching key are set to the -fill value
+     except for their key column which is set to the key.`)
+	flag.BoolVar(&spec.Sorted, "sorted", false,
+		`has no effect and is only accepted for compatibility. Sorted input is
+     detected automatically, see -k and -groupby.`)
+	flag.StringVar(&spec.GroupBy, "groupby", "",
+		`group the output rows by the values of the given key columns, e.g. "0" or
+     "0,2", and print one line per group consisting of the key followed by
+     the results of the -c actions applied to the values of the group's
+     target columns. Columns refer to the output row and can be given by name
+     with -header. Groups are printed in the order of their first row. As
+     long as the rows are sorted by key each group is printed as soon as it
+     is complete. From the first key out of order on all groups are kept in
+     memory, and rows of groups printed before form groups of their own.`)
+	flag.StringVar(&spec.Target, "target", "",
+		`the columns of the output row aggregated by -groupby. The values of all
+     target columns of all rows in a group are pooled, e.g. to average
+     replicate files pasted side by side. Defaults to all non-key columns.`)
+	flag.StringVar(&spec.Uneven, "uneven", "pad",
+		`policy for input files with different numbers of rows. Supported are
+         - pad     : pad the rows of files which ended early with the fill value
+         - shortest: stop once the shortest file ends
+         - strict  : fail with an error naming the file which ended early`)
+	flag.StringVar(&spec.Fill, "fill", "",
+		`fill value for missing columns, e.g. "NA" or "0". It is used for files
+     which ended early in pad mode and for missing keys in outer and left joins.`)
+	flag.IntVar(&numThreads, "n", 1,
+		`number of threads. Line oriented regular files of at least 4 MB are
+     split into chunks at newlines which are tokenized by this many workers
+     per file while the original row order is kept. Smaller files, pipes and
+     csv files are always parsed sequentially.`)
 }
 
 func main() {
-	runtime.GOMAXPROCS(numThreads)
-
 	flag.Parse()
+	runtime.GOMAXPROCS(numThreads)
+	spec.Workers = numThreads
 	if showHelp {
 		usage()
 		help()
@@ -102,540 +206,108 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
-	fileNames := flag.Args()
-	numFileNames := len(fileNames)
-
-	// an outputSpec requires a valid inputSpec
-	if len(spec.output) != 0 && len(spec.input) == 0 {
-		log.Fatal("An output paste spec requires an input column spec.")
-	}
-
-	inputSepFunc := getInputSepFunc(spec.inputSep)
-
-	inCols, err := getInputSpec(spec.input, numFileNames)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	totNumCols := totalLen(inCols)
-	outCols, err := getOutputSpec(spec.output, totNumCols)
-	if err != nil {
-		log.Fatal(err)
-	}
 
-	rowRanges, err := getRowSpec(spec.rows)
+	pipeline, err := paste.NewPipeline(spec)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	computeActions, err := getComputeSpecs(spec.compute)
+	inputs, files, err := openFiles(flag.Args())
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	err = parseData(fileNames, inCols, outCols, rowRanges, inputSepFunc,
-		spec.outputSep, computeActions)
+	err = pipeline.Run(os.Stdout, inputs...)
+	closeFiles(files)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-// parseData parses each of the data files provided on the command line in
-// in a separate goroutine. The done channel used to signal each goroutine to
-// shut down. The errCh channel signals any file opening/parsing issues back
-// to the calling function.
-func parseData(fileNames []string, inCols []parseSpec, outCols parseSpec,
-	rowRanges []rowRange, inputSepFun func(rune) bool, outSep string,
-	actions computeSpec) error {
-
-	var wg sync.WaitGroup
-	done := make(chan struct{})
-	errCh := make(chan error, len(fileNames))
-	defer close(errCh)
-
-	var dataChs []chan []string
-	for i, name := range fileNames {
-		dataCh := make(chan []string, 10000) // use buffered channels to not stall IO
-		dataChs = append(dataChs, dataCh)
-		wg.Add(1)
-		go fileParser(name, inCols[i], rowRanges, inputSepFun, dataCh, done, errCh, &wg)
-	}
-
-	err := processData(dataChs, errCh, outCols, outSep, actions)
-	close(done)
-	wg.Wait()
-
-	return err
-}
-
-// processData goes through all channels delivering data assembling each row
-// and then printing it out
-func processData(dataChs []chan []string, errCh <-chan error, outCols parseSpec,
-	outSep string, actions computeSpec) error {
-
-	var inRow []string
-	defaultInRows := make([][]string, len(dataChs))
-	deadChannels := make([]bool, len(dataChs))
-	activeChannels := len(dataChs)
-	outRow := make([]string, len(outCols))
-	output := bufio.NewWriter(os.Stdout)
-	defer output.Flush()
-	for row := 0; ; row++ {
-		// process each data channel to read the column entries for the current row
-		var in int
-		for i, ch := range dataChs {
-			select {
-			case cols := <-ch:
-				if cols == nil {
-					if !deadChannels[i] {
-						deadChannels[i] = true
-						activeChannels--
-					}
-					if activeChannels == 0 {
-						return nil // all channels are done reading so we're done, too
-					}
-					cols = defaultInRows[i]
-				}
-				// When we hit the first row we initialize the inRow array. For all
-				// subsequent rows we can recycle it for efficiency (UGLY I know)
-				if row == 0 {
-					for _, c := range cols {
-						inRow = append(inRow, c)
-					}
-					defaultInRows[i] = make([]string, len(cols))
-				} else {
-					for _, c := range cols {
-						inRow[in] = c
-						in++
-					}
-				}
-			case err := <-errCh:
-				return err
-			}
-		}
-
-		// assemble output based on outCols if requested
-		if len(outCols) == 0 {
-			outRow = inRow
-		} else {
-			for i, c := range outCols {
-				outRow[i] = inRow[c]
-			}
-		}
-
-		if err := printRow(output, outRow, outSep, actions); err != nil {
-			return err
-		}
-	}
-}
+// stdinName is the file name referring to standard input
+const stdinName = "-"
 
-// printRow creates output based on the provided row. If a computeSpec is provided
-// the requested compute actions will be performed and printed. If computeSpec
-// is empty the row will be printed as is.
-func printRow(output *bufio.Writer, outRow []string, outSep string, actions computeSpec) error {
+// openFiles opens all input files. The file name "-" refers to stdin and can
+// be given at most once. Named pipes such as /dev/fd/N are opened like
+// regular files.
+func openFiles(fileNames []string) ([]paste.Input, []io.Closer, error) {
 
-	if len(actions) > 0 {
-		items, err := splitIntoFloats(outRow)
-		if err != nil {
-			return err
-		}
-		outRow = make([]string, len(actions))
-		for i, a := range actions {
-			outRow[i] = fmt.Sprintf("%15.15f", a(items))
+	numStdin := 0
+	for _, name := range fileNames {
+		if name == stdinName {
+			numStdin++
 		}
 	}
-
-	fmt.Fprintf(output, "%s\n", strings.Join(outRow, outSep))
-	return nil
-}
-
-// fileParser opens fileName, parses it in a line by line fashion and sends
-// the requested columns combined into a string down the data channel.
-// If it receives on the done channel it stops processing and returns
-func fileParser(fileName string, colSpec parseSpec, rowRanges rowRangeSlice,
-	sepFun func(rune) bool, data chan<- []string, done <-chan struct{},
-	errCh chan<- error, wg *sync.WaitGroup) {
-
-	defer wg.Done()
-	defer close(data)
-
-	// open file
-	file, err := os.Open(fileName)
-	if err != nil {
-		errCh <- err
-		return
+	if numStdin > 1 {
+		return nil, nil, fmt.Errorf("stdin (%s) can only be used as input once", stdinName)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	count := -1
-	maxRow := rowRanges.maxEntry()
-	for scanner.Scan() {
 
-		// logic for only printing requested rows
-		count++
-		if count > maxRow {
-			break
-		}
-		if !rowRanges.contains(count) {
+	var inputs []paste.Input
+	var files []io.Closer
+	for _, name := range fileNames {
+		if name == stdinName {
+			inputs = append(inputs, paste.Input{Name: "stdin", Reader: os.Stdin})
 			continue
 		}
-
-		var row []string
-		// an empty colSpec signals all rows
-		if len(colSpec) == 0 {
-			row = append(row, scanner.Text())
-		} else {
-			row = make([]string, len(colSpec))
-			items := strings.FieldsFunc(strings.TrimSpace(scanner.Text()), sepFun)
-			for i, c := range colSpec {
-				if c >= len(items) {
-					errCh <- fmt.Errorf("error parsing file %s: requested column %d "+
-						"does not exist", fileName, c)
-					return
-				}
-				row[i] = items[c]
-			}
-		}
-
-		select {
-		case data <- row:
-		case <-done:
-			return
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		errCh <- err
-	}
-	return
-}
-
-// getInputSpec parses, checks, and the returns the inputSpecs
-// NOTE: We pad the list of parseSpecs with the final supplied entry if there
-// are more files than provided spec entries
-func getInputSpec(input string, numFiles int) ([]parseSpec, error) {
-	var inCols []parseSpec
-	var err error
-	if input == "" {
-		return inCols, err
-	}
-
-	if inCols, err = parseInputSpec(input); err != nil {
-		return inCols, err
-	}
-	if len(inCols) > numFiles {
-		return inCols, fmt.Errorf("there are more per file column specifiers than supplied input files")
-	}
-	finalSpec := inCols[len(inCols)-1]
-	pading := numFiles - len(inCols)
-	for i := 0; i < pading; i++ {
-		inCols = append(inCols, finalSpec)
-	}
-	return inCols, err
-}
-
-// parseInputSpec parses the inputSpec and turns it into a slice of parseSpecs,
-// one for each input file. An empty inputSpec is assumed to imply that the
-// user wants to grab all columns in each file
-func parseInputSpec(input string) ([]parseSpec, error) {
-
-	if len(input) == 0 {
-		return []parseSpec{parseSpec{}}, nil
-	}
-
-	// split according to file specs
-	fileSpecs := strings.Split(input, "|")
-
-	spec := make([]parseSpec, len(fileSpecs))
-	// split according to column specs
-	for i, f := range fileSpecs {
-		colSpecs := strings.Split(f, ",")
-		if len(colSpecs) == 1 {
-			return nil, fmt.Errorf("empty input specification for file entry #%d: %s",
-				i, f)
-		}
-
-		var ps parseSpec
-		for _, cr := range colSpecs {
-			c := strings.TrimSpace(cr)
-			begin, end, err := parseRange(c)
-			if err != nil {
-				return nil, err
-			}
-			ps = append(ps, makeIntRange(begin, end)...)
-		}
-		spec[i] = ps
-	}
-	return spec, nil
-}
-
-// getOutputSpec parses, checks and then returns the outputSpecs
-func getOutputSpec(output string, numCols int) (parseSpec, error) {
-
-	var outCols parseSpec
-	var err error
-	if output == "" {
-		return outCols, err
-	}
-
-	if outCols, err = parseOutputSpec(output); err != nil {
-		return outCols, err
-	}
-
-	min, max := outCols.minMax()
-	if max > numCols || min < 0 {
-		return outCols, fmt.Errorf("at least one output column specifier is out of bounds or negative %d %d %d", min, max, numCols)
-	}
-
-	return outCols, nil
-}
-
-// parseOutputSpec parses the comma separated list of output columns
-func parseOutputSpec(input string) (parseSpec, error) {
-
-	fileSpecs := strings.Split(input, ",")
-	var spec parseSpec
-	for _, f := range fileSpecs {
-		begin, end, err := parseRange(f)
-		if err != nil {
-			return spec, err
-		}
-		spec = append(spec, makeIntRange(begin, end)...)
-	}
-	return spec, nil
-}
-
-// getRowSpec parses, checks, and returns the rowSpecs
-func getRowSpec(rows string) ([]rowRange, error) {
-
-	var rowRanges rowRangeSlice
-	var err error
-	if rows == "" {
-		return rowRanges, err
-	}
-
-	if rowRanges, err = parseRowSpec(spec.rows); err != nil {
-		return rowRanges, err
-	}
-	sort.Sort(rowRanges)
-	return rowRanges, nil
-}
-
-// parseRowSpec parses the comma separated list of row ranges to output
-func parseRowSpec(input string) ([]rowRange, error) {
-
-	rowSpecs := strings.Split(input, ",")
-	rowRanges := make([]rowRange, len(rowSpecs))
-	for i, r := range rowSpecs {
-		begin, end, err := parseRange(strings.TrimSpace(r))
-		if err != nil {
-			return nil, err
-		}
-		if end < begin {
-			return nil, fmt.Errorf("the end of interval %s is smaller than its beginning", r)
-		}
-		rowRanges[i] = rowRange{begin, end}
-	}
-	return rowRanges, nil
-}
-
-// getComputeSpecs parses, checks and returns the compute actions to be
-// performed on the data set
-func getComputeSpecs(actions string) (computeSpec, error) {
-
-	var specs computeSpec
-	if actions == "" {
-		return specs, nil
-	}
-	return parseComputeSpec(actions)
-}
-
-// parseComputeSpec parses the comma separated list of compute actions
-func parseComputeSpec(actions string) (computeSpec, error) {
-
-	var act computeAction
-	items := strings.Split(actions, ",")
-	specs := make(computeSpec, len(items))
-	for i, r := range items {
-		val := strings.TrimSpace(r)
-		fmt.Println(val)
-		switch val {
-		case "mean":
-			act = mean
-		case "var":
-			act = variance
-		case "std":
-			act = func(x []float64) float64 { return math.Sqrt(variance(x)) }
-		case "max":
-			act = max
-		case "min":
-			act = min
-		case "median":
-			act = median
-		default:
-			return specs, fmt.Errorf("Encountered unknown compute action %s", val)
-		}
-		specs[i] = act
-	}
-	return specs, nil
-}
-
-// parseRange parses a range string of the form "a" or a-b", where both a and
-// b are integers and "a" is equal to "a-(a+1)". It returns the beginning and
-// end of the range
-func parseRange(input string) (int, int, error) {
-
-	// check for possible range
-	rangeSpec := strings.Split(input, "-")
-
-	var begin, end int
-	var err error
-	switch len(rangeSpec) {
-	case 1: // no range, simple columns
-		begin, err = strconv.Atoi(input)
-		if err != nil {
-			return begin, end, fmt.Errorf("could not convert %s into integer representation",
-				input)
-		}
-		end = begin
-	case 2: // range specified via begin and end
-		begin, err = strconv.Atoi(rangeSpec[0])
-		if err != nil {
-			return begin, end, fmt.Errorf("could not convert %s into integer representation",
-				rangeSpec[0])
-		}
-
-		end, err = strconv.Atoi(rangeSpec[1])
+		file, err := os.Open(name)
 		if err != nil {
-			return begin, end, fmt.Errorf("could not convert %s into integer representation",
-				rangeSpec[1])
+			closeFiles(files)
+			return nil, nil, err
 		}
-	default:
-		return begin, end, fmt.Errorf("incorrect column range specification %s", input)
+		inputs = append(inputs, paste.Input{Name: name, Reader: file})
+		files = append(files, file)
 	}
-	return begin, end, nil
+	return inputs, files, nil
 }
 
-// splitIntoFloats splits a string consisting of whitespace separated floats
-// into a list of floats.
-func splitIntoFloats(items []string) ([]float64, error) {
-
-	var floatList []float64
-	for _, item := range items {
-		val, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
-		if err != nil {
-			return nil, err
-		}
-		floatList = append(floatList, val)
+// closeFiles closes all provided files
+func closeFiles(files []io.Closer) {
+	for _, f := range files {
+		f.Close()
 	}
-	return floatList, nil
 }
 
-// totalLen computes the totla number of items contained in a list of parseSpecs
-func totalLen(spec []parseSpec) int {
-	var totLen int
-	for _, s := range spec {
-		totLen += len(s)
-	}
-	return totLen
-}
+// actionHelp lists the registered compute actions together with their
+// descriptions for the help message
+func actionHelp() string {
 
-// getInputSepFunc returns a closure used for separating the columns in the
-// input files
-func getInputSepFunc(inputSep string) func(rune) bool {
-	inputSepFunc := unicode.IsSpace
-	if len(inputSep) >= 1 {
-		inputSepFunc = func(r rune) bool {
-			for _, s := range inputSep {
-				if s == r {
-					return true
-				}
-			}
-			return false
+	actions := paste.Actions()
+	width := 0
+	for _, a := range actions {
+		if n := len(a.Usage()); n > width {
+			width = n
 		}
 	}
-	return inputSepFunc
-}
-
-// makeIntRange creates a slice of consecutive ints starting at begin until
-// and includise end.
-// NOTE: This function assumes end >= begin
-func makeIntRange(begin, end int) []int {
-	r := make([]int, 0, end-begin+1)
-	for i := begin; i <= end; i++ {
-		r = append(r, i)
-	}
-	return r
-}
 
-// minMax returns the minimum and maximum value in a parseSpec
-func (p parseSpec) minMax() (int, int) {
-	maxVal := -math.MaxInt64
-	minVal := math.MaxInt64
-	for _, v := range p {
-		if v > maxVal {
-			maxVal = v
-		} else if v < minVal {
-			minVal = v
-		}
+	var lines []string
+	for _, a := range actions {
+		prefix := fmt.Sprintf("         - %-*s: ", width, a.Usage())
+		lines = append(lines, wrapText(a.Description, prefix, helpWidth)...)
 	}
-	return minVal, maxVal
-}
-
-// rowRange is used to specify row ranges to be processed
-type rowRange struct {
-	b, e int
+	return strings.Join(lines, "\n")
 }
 
-// contains tests if the provided integer value is contained within the supplied
-// row range slice. The row range is assumed to be sorted.
-// NOTE: An empty rowRangeSlice as a special case returns always true to
-// enable the default case in which no row processing is specified
-func (rr rowRangeSlice) contains(v int) bool {
-	if len(rr) == 0 {
-		return true
-	}
-
-	for _, r := range rr {
-		if v < r.b {
-			return false
-		} else if v <= r.e {
-			return true
+// helpWidth is the maximum length of generated help lines
+const helpWidth = 80
+
+// wrapText splits text into lines of at most width characters. The first
+// line starts with prefix and all others are indented to match it. Words
+// longer than the available width are not split.
+func wrapText(text, prefix string, width int) []string {
+
+	indent := strings.Repeat(" ", len(prefix))
+	var lines []string
+	line := prefix
+	for _, word := range strings.Fields(text) {
+		if len(line) > len(indent) && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = indent
 		}
-	}
-	return false
-}
-
-// maxEntry contains the largest integer value in the rowRangeSlice
-// NOTE: If the rowRangeSlice is empty we return MaxInt
-func (rr rowRangeSlice) maxEntry() int {
-	if len(rr) == 0 {
-		return math.MaxInt64
-	}
-
-	var max int
-	for _, r := range rr {
-		if max < r.e {
-			max = r.e
+		if len(line) > len(indent) {
+			line += " "
 		}
+		line += word
 	}
-	return max
-}
-
-// rowRangeSlice is a helper type to enable sorting
-type rowRangeSlice []rowRange
-
-// sort functionality for rowRangeSlice
-func (rr rowRangeSlice) Len() int {
-	return len(rr)
-}
-
-func (rr rowRangeSlice) Swap(i, j int) {
-	rr[i], rr[j] = rr[j], rr[i]
-}
-
-func (rr rowRangeSlice) Less(i, j int) bool {
-	return rr[i].b < rr[j].b
+	return append(lines, line)
 }
 
 // usage prints a simple usage message
@@ -650,7 +322,7 @@ func usage() {
 
 // help prints a simple help message
 func help() {
-	fmt.Println(exampleText)
+	fmt.Print(exampleText)
 }
 
 const exampleText = `Notes:
@@ -658,6 +330,14 @@ const exampleText = `Notes:
     The output file is assembled in memory and thus requires sufficient storage
     to hold the complete final output data.
 
+    A file name of "-" reads from stdin and may be given once. Named pipes and
+    process substitutions such as <(command) can be used like regular files.
+
+    Input files compressed with gzip, bzip2, xz, or zstd are detected by their
+    magic bytes and decompressed on the fly. Decompressing xz and zstd files
+    requires the xz and zstd command line tools, respectively, to be installed
+    in the PATH. Otherwise pst stops with an error naming the tool and file.
+
     The input column specifiers are zero based and can include ranges. The end
     of a range is included in the output, i.e. the range 2-5 selects columns
     2, 3, 4, 5.
@@ -690,6 +370,52 @@ Examples:
     by ','.
 
 
+    pst -csv -i "0,2" file1.csv file2.csv > outfile
+
+    This command parses file1.csv and file2.csv as csv files and selects the
+    csv fields 0 and 2 from each. Quoted fields such as "Smith, John" count
+    as a single column.
+
+
+    pst -i "0,3|1-2" -o "1:2,0:0,0:3" file1 file2 > outfile
+
+    This command prints column 2 of file2 followed by columns 0 and 3 of
+    file1. Without qualifying the columns by file this requires -o "3,0,1".
+
+
+    pst -i "0,1|1" -e "c0, c2/c1, log(c1)*1000" file1 file2 > outfile
+
+    This command prints column 0 of file1, the ratio of column 1 of file2
+    and column 1 of file1, and the scaled logarithm of column 1 of file1.
+
+
+    pst -i "0,1" -rolling "mean(c1, 10), median(c1, 10)" file1 > outfile
+
+    This command prints columns 0 and 1 of file1 followed by the mean and
+    median of column 1 over a sliding window of the current and the previous
+    9 rows.
+
+
+    pst -i "0,1|1" -k "0|0" -join left file1 file2 > outfile
+
+    This command joins file1 and file2 on their first column. Each row of
+    file1 is printed together with column 1 of all rows of file2 with the
+    same key. Rows of file1 without a match in file2 get an empty column.
+
+
+    pst -i "0,1|1" -groupby 0 -c "mean,std" file1 file2 > outfile
+
+    This command groups the rows by the value of column 0 of file1 and
+    prints for each group its key followed by the mean and standard deviation
+    of column 1 of file1 and file2 across all rows of the group.
+
+
+    pst -header -i "0,1|1" -outformat jsonl file1 file2 > outfile
+
+    This command prints the selected columns as JSON Lines, i.e. one JSON
+    object per row with the header names of the columns as keys.
+
+
     pst -c -t "," -s ";" -i "0,1|3|4-5" file1 file2 file3 > outfile
 
     Same as above but instead of outputting 5 columns, it computes and prints
@@ -1,127 +1,133 @@
-// unit tests for pst
+// unit tests for the pst command
 package main
 
 import (
-	"sort"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"testing"
-)
-
-// Test_rowRangeSlices tests the rowRangeSlice data structure
-func Test_rowRangeSlice(t *testing.T) {
-	var rr rowRangeSlice
-	rr = append(rr, rowRange{11, 20})
-	rr = append(rr, rowRange{4, 9})
-	rr = append(rr, rowRange{2, 5})
 
-	sort.Sort(rr)
-	if rr[0].b != 2 || rr[1].b != 4 || rr[2].b != 11 {
-		t.Error("error sorting rowRangeSlice")
-	}
+	"github.com/haskelladdict/pst/paste"
+)
 
-	if rr.contains(1) || rr.contains(10) || rr.contains(21) {
-		t.Error("false positive during rowRangeSLice.contains lookup")
-	}
+// Test_openFilesStdin checks that stdin can be used as input at most once
+func Test_openFilesStdin(t *testing.T) {
 
-	if !rr.contains(2) || !rr.contains(9) || !rr.contains(3) || !rr.contains(11) ||
-		!rr.contains(17) {
-		t.Error("false negative during rowRangeSLice.contains lookup")
+	if _, _, err := openFiles([]string{"-", "-"}); err == nil {
+		t.Error("failed to reject stdin given as input twice")
 	}
 
-	if rr.maxEntry() != 20 {
-		t.Error("error during rowRangeSLice.maxEntry")
-		return
-	}
-}
-
-// Test_parseInputSpec checks that parseInputSpec() properly parses the provided
-// input spec string
-func Test_parseInputSpec(t *testing.T) {
-
-	inputString := "0,1-3,10|14,7,2|1,1-4"
-	expectedResult := []parseSpec{parseSpec{0, 1, 2, 3, 10}, parseSpec{14, 7, 2},
-		parseSpec{1, 1, 2, 3, 4}}
-	result, err := parseInputSpec(inputString)
+	inputs, files, err := openFiles([]string{"-"})
 	if err != nil {
 		t.Error(err)
 		return
 	}
-
-	if len(result) != len(expectedResult) {
-		t.Errorf("length mismatch between expected and computed result")
-		return
+	if len(inputs) != 1 || inputs[0].Name != "stdin" || len(files) != 0 {
+		t.Errorf("expected a single stdin input but got %v", inputs)
 	}
+}
 
-	for i, r := range result {
-		if !parseSpecsIdentical(r, expectedResult[i]) {
-			t.Errorf("expected %v and computed %v results don't match", r, expectedResult[i])
+// Test_outputSep checks that the output separator defaults to a single space
+// and that an empty separator joins the columns directly
+func Test_outputSep(t *testing.T) {
+
+	defer func(s paste.Spec) { spec = s }(spec)
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-i", "0,1"}, "1 2\n"},
+		{[]string{"-t", "", "-i", "0,1"}, "12\n"},
+		{[]string{"-t", ",", "-i", "0,1"}, "1,2\n"},
+	} {
+		if err := flag.CommandLine.Parse(test.args); err != nil {
+			t.Error(err)
+			return
+		}
+		p, err := paste.NewPipeline(spec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := p.Run(&buf, paste.Input{Name: "a", Reader: strings.NewReader("1 2\n")}); err != nil {
+			t.Error(err)
 			return
 		}
+		if buf.String() != test.expected {
+			t.Errorf("expected %q and computed %q output for %q don't match",
+				test.expected, buf.String(), test.args)
+		}
 	}
 }
 
-// Test_parseInputSpec checks that parseInputSpec() properly parses the provided
-// input spec string
-func Test_parseOutputSpec(t *testing.T) {
+// Test_actionHelp checks that all registered compute actions are listed in
+// the help message and that long descriptions are wrapped
+func Test_actionHelp(t *testing.T) {
 
-	inputString := "0,1-3,10,14,7,2,1,4"
-	expectedResult := parseSpec{0, 1, 2, 3, 10, 14, 7, 2, 1, 4}
-	result, err := parseOutputSpec(inputString)
-	if err != nil {
-		t.Error(err)
-		return
+	help := actionHelp()
+	for _, a := range paste.Actions() {
+		if !strings.Contains(help, "- "+a.Usage()) {
+			t.Errorf("compute action %s is missing from the help message", a.Usage())
+		}
 	}
-
-	if len(result) != len(expectedResult) {
-		t.Errorf("length mismatch between expected and computed result")
-		return
+	for _, line := range strings.Split(help, "\n") {
+		if len(line) > helpWidth {
+			t.Errorf("help line %q exceeds %d characters", line, helpWidth)
+		}
 	}
+}
 
-	if !parseSpecsIdentical(result, expectedResult) {
-		t.Errorf("expected %v and computed %v results don't match", result, expectedResult)
-		return
+// benchmarkInput returns a narrow input file with the given number of rows
+func benchmarkInput(rows int) string {
+	var input bytes.Buffer
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&input, "%d %d.5\n", i, i%97)
 	}
+	return input.String()
 }
 
-// Test_parseRowSpec checks that parseRowSpec() properly parses the provided
-// row spec string
-func Test_parseRowSpec(t *testing.T) {
+// benchmarkPipeline runs the pipeline for spec on numFiles copies of a
+// narrow input file
+func benchmarkPipeline(b *testing.B, spec paste.Spec, numFiles int) {
 
-	inputString := "0,1-3,10,14,7,2,1-4"
-	expectedResult := []rowRange{rowRange{0, 0}, rowRange{1, 3}, rowRange{10, 10},
-		rowRange{14, 14}, rowRange{7, 7}, rowRange{2, 2}, rowRange{1, 4}}
-	result, err := parseRowSpec(inputString)
+	input := benchmarkInput(100000)
+	p, err := paste.NewPipeline(spec)
 	if err != nil {
-		t.Error(err)
-		return
-	}
-
-	if len(result) != len(expectedResult) {
-		t.Errorf("length mismatch between expected and computed result")
-		return
+		b.Fatal(err)
 	}
-
-	var er rowRange
-	for i, rr := range result {
-		er = expectedResult[i]
-		if rr.b != er.b || rr.e != er.e {
-			t.Errorf("expected %v and computed %v results don't match", rr, er)
-			return
+	b.SetBytes(int64(numFiles * len(input)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		inputs := make([]paste.Input, numFiles)
+		for i := range inputs {
+			inputs[i] = paste.Input{Name: "f" + strconv.Itoa(i), Reader: strings.NewReader(input)}
+		}
+		if err := p.Run(io.Discard, inputs...); err != nil {
+			b.Fatal(err)
 		}
 	}
 }
 
-// parseSpecsIdentical is a helper function for checking two parseSpecs for identity
-func parseSpecsIdentical(x, y parseSpec) bool {
-	if len(x) != len(y) {
-		return false
-	}
+// Benchmark_pasteColumns pastes one column of each of two narrow files
+func Benchmark_pasteColumns(b *testing.B) {
+	benchmarkPipeline(b, paste.Spec{Input: "1", OutputSep: " "}, 2)
+}
 
-	for i, v := range x {
-		if v != y[i] {
-			return false
-		}
-	}
+// Benchmark_pasteRows pastes complete rows of two narrow files
+func Benchmark_pasteRows(b *testing.B) {
+	benchmarkPipeline(b, paste.Spec{OutputSep: " "}, 2)
+}
 
-	return true
+// Benchmark_computeMean computes the row mean across four narrow files
+func Benchmark_computeMean(b *testing.B) {
+	benchmarkPipeline(b, paste.Spec{Input: "0-1", OutputSep: " ", Compute: "mean"}, 4)
+}
 
+// Benchmark_sortedJoin joins two narrow files sorted by key
+func Benchmark_sortedJoin(b *testing.B) {
+	benchmarkPipeline(b, paste.Spec{Input: "1", Keys: "0", Sorted: true, OutputSep: " "}, 2)
 }